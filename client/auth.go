@@ -2,31 +2,53 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	pb "github.com/simp7/pracgrpc/model/ecommerce"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
-	"log"
 	"time"
 )
 
+var errPasswordDiscarded = errors.New("password was discarded after the first login")
+
 type AuthClient struct {
-	service  pb.AuthServiceClient
-	username string
-	password string
+	service      pb.AuthServiceClient
+	username     string
+	password     string
+	secondFactor string
+	refreshToken string
+	keepPassword bool
 }
 
-type AuthInterceptor struct {
-	authClient  *AuthClient
-	authMethods map[string]bool
-	accessToken string
+type AuthClientOption func(client *AuthClient)
+
+// WithRelogin keeps the password in memory for the lifetime of the client, so
+// it can log in again once its refresh token stops working. Without it the
+// password is dropped after the first login and the session ends with the
+// refresh token, trading availability for a shorter exposure of the password.
+func WithRelogin() AuthClientOption {
+	return func(client *AuthClient) {
+		client.keepPassword = true
+	}
 }
 
-func NewAuthClient(cc *grpc.ClientConn, username string, password string) *AuthClient {
+// NewAuthClient creates a client logging in as username. secondFactor is a
+// TOTP or recovery code, needed only when the user has enabled TOTP.
+func NewAuthClient(cc *grpc.ClientConn, username string, password string, secondFactor string, opts ...AuthClientOption) *AuthClient {
 	service := pb.NewAuthServiceClient(cc)
-	return &AuthClient{service, username, password}
+	client := &AuthClient{service: service, username: username, password: password, secondFactor: secondFactor}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client
 }
 
 func (client *AuthClient) Login() (string, error) {
+	if !client.CanLogin() {
+		return "", errPasswordDiscarded
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -39,79 +61,69 @@ func (client *AuthClient) Login() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return res.GetAccessToken(), nil
-}
 
-func NewAuthInterceptor(authClient *AuthClient, authMethods map[string]bool, refreshDuration time.Duration) (*AuthInterceptor, error) {
-	interceptor := &AuthInterceptor{
-		authClient:  authClient,
-		authMethods: authMethods,
+	if res.GetSecondFactorRequired() {
+		if client.secondFactor == "" {
+			return "", fmt.Errorf("user %s requires a second factor code", client.username)
+		}
+		res, err = client.service.VerifySecondFactor(ctx, &pb.VerifySecondFactorRequest{
+			Challenge: res.GetChallenge(),
+			Code:      client.secondFactor,
+		})
+		if err != nil {
+			return "", err
+		}
 	}
 
-	err := interceptor.scheduleRefreshToken(refreshDuration)
-	if err != nil {
-		return nil, err
+	client.secondFactor = ""
+	if !client.keepPassword {
+		client.password = ""
 	}
-
-	return interceptor, nil
+	client.refreshToken = res.GetRefreshToken()
+	return res.GetAccessToken(), nil
 }
 
-func (interceptor *AuthInterceptor) refreshToken() error {
-	accessToken, err := interceptor.authClient.Login()
-	if err != nil {
-		return err
-	}
-
-	interceptor.accessToken = accessToken
-	log.Printf("token refreshed: %v", accessToken)
-
-	return nil
+// CanLogin reports whether the client still holds the password.
+func (client *AuthClient) CanLogin() bool {
+	return client.password != ""
 }
 
-func (interceptor *AuthInterceptor) scheduleRefreshToken(refreshDuration time.Duration) error {
-	err := interceptor.refreshToken()
-	if err != nil {
-		return err
-	}
+func (client *AuthClient) HasRefreshToken() bool {
+	return client.refreshToken != ""
+}
 
-	go func() {
-		wait := refreshDuration
-		for {
-			time.Sleep(wait)
-			err = interceptor.refreshToken()
-			if err != nil {
-				wait = time.Second
-			} else {
-				wait = refreshDuration
-			}
-		}
-	}()
+func (client *AuthClient) RefreshToken() string {
+	return client.refreshToken
+}
 
-	return nil
+// SetRefreshToken resumes a session whose refresh token was kept elsewhere.
+func (client *AuthClient) SetRefreshToken(refreshToken string) {
+	client.refreshToken = refreshToken
 }
 
-func (interceptor *AuthInterceptor) Unary() grpc.UnaryClientInterceptor {
-	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		log.Printf("--> unary interceptor: %s", method)
+func (client *AuthClient) Refresh() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-		if interceptor.authMethods[method] {
-			return invoker(interceptor.attachToken(ctx), method, req, reply, cc, opts...)
-		}
-		return invoker(ctx, method, req, reply, cc, opts...)
+	req := &pb.RefreshRequest{
+		RefreshToken: client.refreshToken,
 	}
-}
-
-func (interceptor *AuthInterceptor) Stream() grpc.StreamClientInterceptor {
-	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-		log.Printf("--> stream interceptor: %s", method)
 
-		if interceptor.authMethods[method] {
-			return streamer(interceptor.attachToken(ctx), desc, cc, method, opts...)
-		}
-		return streamer(ctx, desc, cc, method, opts...)
+	res, err := client.service.Refresh(ctx, req)
+	if err != nil {
+		return "", err
 	}
+
+	client.refreshToken = res.GetRefreshToken()
+	return res.GetAccessToken(), nil
 }
 
-func (interceptor *AuthInterceptor) attachToken(ctx context.Context) context.Context {
-	return metadata.AppendToOutgoingContext(ctx, "authorization", interceptor.accessToken)
+// Logout revokes accessToken and the session of refreshToken on the server.
+func (client *AuthClient) Logout(accessToken string, refreshToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+accessToken)
+	_, err := client.service.Logout(ctx, &pb.LogoutRequest{RefreshToken: refreshToken})
+	return err
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"log"
+	"time"
+)
+
+var errInsecureTransport = errors.New("refusing to send credentials without transport security")
+
+// JWTCredentials attaches the access token of an AuthClient to RPCs. Use it
+// for a whole connection with grpc.WithPerRPCCredentials or for a single call
+// with grpc.PerRPCCredentials.
+type JWTCredentials struct {
+	tokens        *TokenSource
+	allowInsecure bool
+}
+
+// NewJWTCredentials logs in through authClient, or reuses the tokens in cache
+// when it is not nil, and keeps the token fresh. transport secures the
+// connections of authClient and of the calls, and unless allowInsecure is set
+// it must be more than plaintext. Allowing it is only acceptable against a
+// local development server.
+func NewJWTCredentials(authClient *AuthClient, transport credentials.TransportCredentials, cache *TokenCache, refreshAhead time.Duration, allowInsecure bool) (*JWTCredentials, error) {
+	// Checked before logging in, since the login itself sends the password.
+	if !allowInsecure && (transport == nil || transport.Info().SecurityProtocol == "insecure") {
+		return nil, errInsecureTransport
+	}
+
+	tokens, err := NewTokenSource(authClient, cache, refreshAhead)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWTCredentials{tokens: tokens, allowInsecure: allowInsecure}, nil
+}
+
+func (creds *JWTCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + creds.tokens.Token(),
+	}, nil
+}
+
+func (creds *JWTCredentials) RequireTransportSecurity() bool {
+	return !creds.allowInsecure
+}
+
+// Close stops renewing the access token.
+func (creds *JWTCredentials) Close() {
+	creds.tokens.Close()
+}
+
+// RetryUnary retries a call once with a new token when the server rejects the
+// current one, e.g. because it was revoked.
+func (creds *JWTCredentials) RetryUnary() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		accessToken := creds.tokens.Token()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+
+		if _, reloginErr := creds.tokens.Relogin(accessToken); reloginErr != nil {
+			log.Printf("cannot log in again: %v", reloginErr)
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// RetryStream retries only when opening the stream fails. Once messages have
+// been sent, a rejected stream cannot be replayed.
+func (creds *JWTCredentials) RetryStream() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		accessToken := creds.tokens.Token()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if status.Code(err) != codes.Unauthenticated {
+			return stream, err
+		}
+
+		if _, reloginErr := creds.tokens.Relogin(accessToken); reloginErr != nil {
+			log.Printf("cannot log in again: %v", reloginErr)
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func newTestJWTCredentials(t *testing.T, service *fakeAuthService) *JWTCredentials {
+	t.Helper()
+
+	authClient := &AuthClient{service: service, username: "alice", password: "secret", keepPassword: true}
+	creds, err := NewJWTCredentials(authClient, credentials.NewTLS(&tls.Config{}), nil, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(creds.Close)
+	return creds
+}
+
+func TestJWTCredentialsRefusePlaintextBeforeLogin(t *testing.T) {
+	tests := []struct {
+		name          string
+		transport     credentials.TransportCredentials
+		allowInsecure bool
+		wantErr       bool
+	}{
+		{"plaintext", insecure.NewCredentials(), false, true},
+		{"no transport", nil, false, true},
+		{"allowed plaintext", insecure.NewCredentials(), true, false},
+		{"tls", credentials.NewTLS(&tls.Config{}), false, false},
+	}
+	for _, test := range tests {
+		service := newFakeAuthService()
+		authClient := &AuthClient{service: service, username: "alice", password: "secret"}
+
+		creds, err := NewJWTCredentials(authClient, test.transport, nil, time.Minute, test.allowInsecure)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: NewJWTCredentials() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		logins, _ := service.calls()
+		if err != nil {
+			if !errors.Is(err, errInsecureTransport) || logins != 0 {
+				t.Errorf("%s: NewJWTCredentials() = %v after %d logins, want a refusal before logging in", test.name, err, logins)
+			}
+			continue
+		}
+		if creds.RequireTransportSecurity() == test.allowInsecure {
+			t.Errorf("%s: RequireTransportSecurity() = %v, want %v", test.name, creds.RequireTransportSecurity(), !test.allowInsecure)
+		}
+		creds.Close()
+	}
+}
+
+func TestJWTCredentialsRequestMetadata(t *testing.T) {
+	creds := newTestJWTCredentials(t, newFakeAuthService())
+
+	md, err := creds.GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md["authorization"] != "Bearer "+creds.tokens.Token() {
+		t.Fatalf("authorization = %q, want the current bearer token", md["authorization"])
+	}
+}
+
+func TestJWTCredentialsRetryUnary(t *testing.T) {
+	service := newFakeAuthService()
+	creds := newTestJWTCredentials(t, service)
+	retry := creds.RetryUnary()
+
+	var attempts int
+	rejectOnce := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempts++
+		if attempts == 1 {
+			return status.Error(codes.Unauthenticated, "token revoked")
+		}
+		return nil
+	}
+	if err := retry(context.Background(), "/ecommerce.ProductInfo/getProduct", nil, nil, nil, rejectOnce); err != nil {
+		t.Fatalf("RetryUnary() error = %v", err)
+	}
+	if logins, _ := service.calls(); attempts != 2 || logins != 2 {
+		t.Fatalf("RetryUnary() made %d attempts and %d logins, want one retry after a relogin", attempts, logins)
+	}
+
+	attempts = 0
+	denied := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempts++
+		return status.Error(codes.PermissionDenied, "no permission")
+	}
+	if err := retry(context.Background(), "/ecommerce.ProductInfo/addProduct", nil, nil, nil, denied); status.Code(err) != codes.PermissionDenied || attempts != 1 {
+		t.Fatalf("RetryUnary() = %v after %d attempts, want the error without a retry", err, attempts)
+	}
+}
+
+func TestJWTCredentialsRetryStream(t *testing.T) {
+	service := newFakeAuthService()
+	creds := newTestJWTCredentials(t, service)
+	retry := creds.RetryStream()
+
+	var attempts int
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		attempts++
+		return nil, status.Error(codes.Unauthenticated, "token revoked")
+	}
+	if _, err := retry(context.Background(), &grpc.StreamDesc{}, nil, "/ecommerce.OrderManagement/searchOrders", streamer); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("RetryStream() error = %v, want %v", err, codes.Unauthenticated)
+	}
+	if logins, _ := service.calls(); attempts != 2 || logins != 2 {
+		t.Fatalf("RetryStream() made %d attempts and %d logins, want a single retry", attempts, logins)
+	}
+}
@@ -2,46 +2,133 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
 	pb "github.com/simp7/pracgrpc/model/ecommerce"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 	"io"
 	"log"
+	"os"
 	"time"
 )
 
 const (
-	address         = "localhost:50051"
-	username        = "admin1"
-	password        = "secret"
-	refreshDuration = time.Second * 30
+	address      = "localhost:50051"
+	username     = "admin1"
+	password     = "secret"
+	refreshAhead = time.Second * 30
 )
 
-func authMethods() map[string]bool {
-	return map[string]bool{
-		"/ecommerce.ProductInfo/addProduct":      true,
-		"/ecommerce.OrderManagement/createOrder": true,
+var (
+	caFile      = flag.String("ca", "", "CA certificate used to verify the server, TLS is disabled when empty")
+	certFile    = flag.String("cert", "", "client certificate file for mutual TLS")
+	keyFile     = flag.String("key", "", "client private key file for mutual TLS")
+	insecureJWT = flag.Bool("insecure-token", false, "send the password and tokens without TLS, only for a local development server")
+	totpCode    = flag.String("totp", "", "TOTP or recovery code, for users who enabled a second factor")
+	relogin     = flag.Bool("relogin", false, "keep the password in memory to log in again when the refresh token stops working")
+	cacheTokens = flag.Bool("token-cache", true, "reuse tokens cached on disk by earlier runs")
+)
+
+func transportCredentials() (credentials.TransportCredentials, error) {
+	if *caFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	pem, err := os.ReadFile(*caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no CA certificate found in %s", *caFile)
+	}
+
+	config := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(config), nil
+}
+
+// logout revokes the cached session on the server and forgets it locally.
+func logout(authClient *AuthClient, cache *TokenCache) {
+	tokens, err := cache.Load()
+	if err != nil {
+		log.Printf("cannot read cached tokens: %v", err)
+	}
+
+	if tokens != nil {
+		if *caFile == "" && !*insecureJWT {
+			log.Print("not sending cached tokens without TLS, they stay valid until they expire")
+		} else if err := authClient.Logout(tokens.AccessToken, tokens.RefreshToken); err != nil {
+			log.Printf("cannot revoke cached tokens: %v", err)
+		}
 	}
+
+	if err := cache.Clear(); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("logged out")
 }
 
 func main() {
-	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	flag.Parse()
+
+	creds, err := transportCredentials()
+	if err != nil {
+		log.Fatal("cannot load transport credentials: ", err)
+	}
+
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
 
 	if err != nil {
 		log.Fatal("cannot dial server: ", err)
 	}
-	authClient := NewAuthClient(conn, username, password)
+	var authOpts []AuthClientOption
+	if *relogin {
+		authOpts = append(authOpts, WithRelogin())
+	}
+	authClient := NewAuthClient(conn, username, password, *totpCode, authOpts...)
+
+	var cache *TokenCache
+	if *cacheTokens || flag.Arg(0) == "logout" {
+		cache, err = NewTokenCache(address, username)
+		if err != nil {
+			log.Fatal("cannot open token cache: ", err)
+		}
+	}
+
+	if flag.Arg(0) == "logout" {
+		logout(authClient, cache)
+		return
+	}
 
-	interceptor, err := NewAuthInterceptor(authClient, authMethods(), refreshDuration)
+	jwtCreds, err := NewJWTCredentials(authClient, creds, cache, refreshAhead, *insecureJWT)
 	if err != nil {
-		log.Fatal("cannot create auth interceptor: ", err)
+		log.Fatal("cannot create token credentials: ", err)
 	}
+	defer jwtCreds.Close()
 
 	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithUnaryInterceptor(interceptor.Unary()),
-		grpc.WithStreamInterceptor(interceptor.Stream()),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(jwtCreds),
+		grpc.WithUnaryInterceptor(jwtCreds.RetryUnary()),
+		grpc.WithStreamInterceptor(jwtCreds.RetryStream()),
 	}
 
 	conn, err = grpc.Dial(address, opts...)
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TokenCache keeps the tokens of one user on one server between runs, so
+// short invocations do not have to log in every time.
+type TokenCache struct {
+	path string
+}
+
+type CachedTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// NewTokenCache returns the cache of username at address, stored under the
+// user cache directory.
+func NewTokenCache(address string, username string) (*TokenCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find cache directory: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(address + "\x00" + username))
+	name := hex.EncodeToString(sum[:16]) + ".json"
+	return &TokenCache{filepath.Join(dir, "pracgrpc", "tokens", name)}, nil
+}
+
+// Load returns the cached tokens, or nil when nothing is cached.
+func (cache *TokenCache) Load() (*CachedTokens, error) {
+	data, err := os.ReadFile(cache.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read token cache: %w", err)
+	}
+
+	tokens := &CachedTokens{}
+	if err := json.Unmarshal(data, tokens); err != nil {
+		return nil, fmt.Errorf("cannot parse token cache: %w", err)
+	}
+	return tokens, nil
+}
+
+// Save replaces the cached tokens. The file is readable only by its owner
+// and is swapped in atomically so a crash never leaves half of it behind.
+func (cache *TokenCache) Save(tokens *CachedTokens) error {
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(cache.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("cannot create token cache directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, ".tokens-*")
+	if err != nil {
+		return fmt.Errorf("cannot create token cache: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot protect token cache: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write token cache: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("cannot write token cache: %w", err)
+	}
+	return os.Rename(file.Name(), cache.path)
+}
+
+func (cache *TokenCache) Clear() error {
+	err := os.Remove(cache.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot remove token cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// Lock does nothing where advisory file locks are unavailable, so parallel
+// runs may still race to refresh the cached tokens there.
+func (cache *TokenCache) Lock() (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Lock takes an advisory lock shared by every run using the cache and returns
+// the function releasing it. It blocks while another run holds the lock.
+func (cache *TokenCache) Lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(cache.path), 0700); err != nil {
+		return nil, fmt.Errorf("cannot create token cache directory: %w", err)
+	}
+
+	file, err := os.OpenFile(cache.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open token cache lock: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot lock token cache: %w", err)
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newTestTokenCache(t *testing.T) *TokenCache {
+	t.Helper()
+	return &TokenCache{path: filepath.Join(t.TempDir(), "tokens", "alice.json")}
+}
+
+func TestTokenCacheRoundTrip(t *testing.T) {
+	cache := newTestTokenCache(t)
+
+	if tokens, err := cache.Load(); tokens != nil || err != nil {
+		t.Fatalf("Load() of an empty cache = %v, %v, want nil, nil", tokens, err)
+	}
+
+	for _, want := range []CachedTokens{{"access-1", "refresh-1"}, {"access-2", "refresh-2"}} {
+		if err := cache.Save(&want); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		got, err := cache.Load()
+		if err != nil || got == nil || *got != want {
+			t.Fatalf("Load() = %v, %v, want %v", got, err, want)
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(cache.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Fatalf("token cache mode = %v, want %v", mode, os.FileMode(0600))
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(cache.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("token cache directory holds %d files, want no temporary file left behind", len(entries))
+	}
+
+	if err := cache.Clear(); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if tokens, err := cache.Load(); tokens != nil || err != nil {
+		t.Fatalf("Load() after Clear() = %v, %v, want nil, nil", tokens, err)
+	}
+	if err := cache.Clear(); err != nil {
+		t.Fatalf("Clear() of an empty cache error = %v", err)
+	}
+}
+
+func TestTokenSourceReplacesCorruptCache(t *testing.T) {
+	cache := newTestTokenCache(t)
+	if err := os.MkdirAll(filepath.Dir(cache.path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache.path, []byte(`{"access_token": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Load(); err == nil {
+		t.Fatal("expected a corrupt cache to fail to load")
+	}
+
+	service := newFakeAuthService()
+	source, err := NewTokenSource(&AuthClient{service: service, username: "alice", password: "secret"}, cache, time.Minute)
+	if err != nil {
+		t.Fatalf("NewTokenSource() with a corrupt cache error = %v", err)
+	}
+	defer source.Close()
+
+	if logins, _ := service.calls(); logins != 1 {
+		t.Fatalf("Login() called %d times, want 1", logins)
+	}
+	tokens, err := cache.Load()
+	if err != nil || tokens == nil || tokens.AccessToken != source.Token() {
+		t.Fatalf("Load() = %v, %v, want the new token cached", tokens, err)
+	}
+}
+
+func TestTokenSourceRestoresCachedToken(t *testing.T) {
+	cache := newTestTokenCache(t)
+	cached := &CachedTokens{AccessToken: testToken(7, time.Now().Add(time.Hour)), RefreshToken: "refresh-7"}
+	if err := cache.Save(cached); err != nil {
+		t.Fatal(err)
+	}
+
+	service := newFakeAuthService()
+	authClient := &AuthClient{service: service, username: "alice", password: "secret"}
+	source, err := NewTokenSource(authClient, cache, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	if logins, refreshes := service.calls(); logins != 0 || refreshes != 0 {
+		t.Fatalf("Login() and Refresh() called %d and %d times, want the cached token reused", logins, refreshes)
+	}
+	if source.Token() != cached.AccessToken || authClient.RefreshToken() != cached.RefreshToken {
+		t.Fatal("expected the cached access and refresh tokens to be taken over")
+	}
+}
+
+func TestTokenSourceReusesTokenCachedWhileLocked(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("token cache locks are advisory flocks, unavailable here")
+	}
+
+	cache := newTestTokenCache(t)
+	service := newFakeAuthService()
+	authClient := &AuthClient{service: service, username: "alice", password: "secret"}
+	source, err := NewTokenSource(authClient, cache, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	stale := source.Token()
+
+	// Hold the lock the way another run refreshing the same cache would.
+	unlock, err := cache.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed := make(chan error, 1)
+	go func() {
+		renewed <- source.renew(stale, false)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	other := &CachedTokens{AccessToken: testToken(99, time.Now().Add(time.Hour)), RefreshToken: "refresh-99"}
+	if err := cache.Save(other); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	if err := <-renewed; err != nil {
+		t.Fatalf("renew() error = %v", err)
+	}
+	if logins, refreshes := service.calls(); logins != 1 || refreshes != 0 {
+		t.Fatalf("Login() and Refresh() called %d and %d times, want the token cached by the other run reused", logins, refreshes)
+	}
+	if source.Token() != other.AccessToken || authClient.RefreshToken() != other.RefreshToken {
+		t.Fatal("expected the tokens cached by the other run to be taken over")
+	}
+}
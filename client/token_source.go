@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const renewRetryDelay = time.Second
+
+// TokenSource keeps an access token valid for concurrent RPCs. It renews the
+// token in the background ahead of its exp claim and lets every caller that
+// needs a new token share a single renewal.
+type TokenSource struct {
+	authClient   *AuthClient
+	cache        *TokenCache
+	refreshAhead time.Duration
+	mutex        sync.Mutex
+	accessToken  string
+	expiresAt    time.Time
+	renewal      *tokenRenewal
+	done         chan struct{}
+	closeOnce    sync.Once
+	wait         sync.WaitGroup
+}
+
+type tokenRenewal struct {
+	done chan struct{}
+	err  error
+}
+
+// NewTokenSource logs in and starts renewing the token refreshAhead before it
+// expires, plus a random jitter so many clients do not renew at once. Tokens
+// found in cache are reused instead of logging in, cache may be nil.
+func NewTokenSource(authClient *AuthClient, cache *TokenCache, refreshAhead time.Duration) (*TokenSource, error) {
+	source := &TokenSource{
+		authClient:   authClient,
+		cache:        cache,
+		refreshAhead: refreshAhead,
+		done:         make(chan struct{}),
+	}
+
+	if !source.restore() {
+		if err := source.renew("", false); err != nil {
+			return nil, err
+		}
+	}
+
+	source.wait.Add(1)
+	go source.renewLoop()
+	return source, nil
+}
+
+// Token returns the current access token.
+func (source *TokenSource) Token() string {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	return source.accessToken
+}
+
+// Relogin replaces stale, a token the server rejected, by logging in again.
+// If another caller already replaced it, the newer token is kept.
+func (source *TokenSource) Relogin(stale string) (string, error) {
+	if err := source.renew(stale, true); err != nil {
+		return "", err
+	}
+	return source.Token(), nil
+}
+
+// Close stops the background renewal.
+func (source *TokenSource) Close() {
+	source.closeOnce.Do(func() {
+		close(source.done)
+	})
+	source.wait.Wait()
+}
+
+// restore takes the cached tokens over. It reports whether the cached access
+// token is still fresh enough to use, otherwise only the refresh token is.
+func (source *TokenSource) restore() bool {
+	if source.cache == nil {
+		return false
+	}
+
+	tokens, err := source.cache.Load()
+	if err != nil {
+		log.Printf("ignoring token cache: %v", err)
+		return false
+	}
+	if tokens == nil {
+		return false
+	}
+
+	source.authClient.SetRefreshToken(tokens.RefreshToken)
+	expiresAt, err := tokenExpiry(tokens.AccessToken)
+	if err != nil || time.Until(expiresAt) <= source.refreshAhead {
+		return false
+	}
+
+	source.accessToken = tokens.AccessToken
+	source.expiresAt = expiresAt
+	log.Printf("reusing cached token")
+	return true
+}
+
+func (source *TokenSource) renewLoop() {
+	defer source.wait.Done()
+
+	for {
+		timer := time.NewTimer(source.nextRenewal())
+		select {
+		case <-source.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := source.renew(source.Token(), false); err != nil {
+			log.Printf("cannot renew access token: %v", err)
+		}
+	}
+}
+
+func (source *TokenSource) nextRenewal() time.Duration {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	if source.renewal != nil || source.expiresAt.IsZero() {
+		return renewRetryDelay
+	}
+
+	wait := time.Until(source.expiresAt) - source.refreshAhead
+	if jitter := int64(source.refreshAhead / 2); jitter > 0 {
+		wait -= time.Duration(rand.Int63n(jitter))
+	}
+	if wait < renewRetryDelay {
+		wait = renewRetryDelay
+	}
+	return wait
+}
+
+// renew replaces the token unless it is no longer stale. Callers arriving
+// while a renewal is running wait for it instead of starting their own.
+func (source *TokenSource) renew(stale string, relogin bool) error {
+	source.mutex.Lock()
+	if renewal := source.renewal; renewal != nil {
+		source.mutex.Unlock()
+		<-renewal.done
+		return renewal.err
+	}
+	if source.accessToken != stale {
+		source.mutex.Unlock()
+		return nil
+	}
+
+	renewal := &tokenRenewal{done: make(chan struct{})}
+	source.renewal = renewal
+	source.mutex.Unlock()
+
+	accessToken, err := source.obtain(stale, relogin)
+	var expiresAt time.Time
+	if err == nil {
+		expiresAt, err = tokenExpiry(accessToken)
+	}
+
+	source.mutex.Lock()
+	if err == nil {
+		source.accessToken = accessToken
+		source.expiresAt = expiresAt
+	}
+	source.renewal = nil
+	source.mutex.Unlock()
+
+	renewal.err = err
+	close(renewal.done)
+	return err
+}
+
+// obtain fetches a new access token while holding the cache lock, so parallel
+// runs sharing the cache never spend the same refresh token twice. A token
+// another run cached in the meantime is taken over instead of fetching.
+func (source *TokenSource) obtain(stale string, relogin bool) (string, error) {
+	if source.cache == nil {
+		return source.fetch(relogin)
+	}
+
+	unlock, err := source.cache.Lock()
+	if err != nil {
+		log.Printf("cannot lock token cache, refreshing without it: %v", err)
+	} else {
+		defer unlock()
+	}
+
+	if accessToken, ok := source.reload(stale); ok {
+		log.Printf("reusing token cached by another run")
+		return accessToken, nil
+	}
+
+	accessToken, err := source.fetch(relogin)
+	if err != nil {
+		return "", err
+	}
+	source.store(accessToken)
+	return accessToken, nil
+}
+
+// reload picks up the refresh token another run may have rotated, and returns
+// the access token it cached if that one is fresh and not stale.
+func (source *TokenSource) reload(stale string) (string, bool) {
+	tokens, err := source.cache.Load()
+	if err != nil || tokens == nil {
+		return "", false
+	}
+
+	if tokens.RefreshToken != "" {
+		source.authClient.SetRefreshToken(tokens.RefreshToken)
+	}
+	if tokens.AccessToken == stale {
+		return "", false
+	}
+	expiresAt, err := tokenExpiry(tokens.AccessToken)
+	if err != nil || time.Until(expiresAt) <= source.refreshAhead {
+		return "", false
+	}
+	return tokens.AccessToken, true
+}
+
+// store caches accessToken along with the refresh token it was issued with.
+// The refresh token rotates on every use, so a stale cache would make the next
+// run present a used token and lose the session.
+func (source *TokenSource) store(accessToken string) {
+	if source.cache == nil {
+		return
+	}
+
+	tokens := &CachedTokens{AccessToken: accessToken, RefreshToken: source.authClient.RefreshToken()}
+	if err := source.cache.Save(tokens); err != nil {
+		log.Printf("cannot cache tokens: %v", err)
+	}
+}
+
+// fetch uses the refresh token when it can and falls back to logging in. A
+// relogin skips the refresh token unless the password is gone.
+func (source *TokenSource) fetch(relogin bool) (string, error) {
+	if (!relogin || !source.authClient.CanLogin()) && source.authClient.HasRefreshToken() {
+		accessToken, err := source.authClient.Refresh()
+		if err == nil {
+			log.Printf("token refreshed")
+			return accessToken, nil
+		}
+		log.Printf("cannot refresh token, logging in again: %v", err)
+	}
+
+	accessToken, err := source.authClient.Login()
+	if err != nil {
+		return "", err
+	}
+	log.Printf("logged in")
+	return accessToken, nil
+}
+
+// tokenExpiry reads the exp claim without verifying the token, which is the
+// server's job.
+func tokenExpiry(accessToken string) (time.Time, error) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("access token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot decode access token: %w", err)
+	}
+
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse access token claims: %w", err)
+	}
+	if claims.ExpiresAt == 0 {
+		return time.Time{}, fmt.Errorf("access token has no exp claim")
+	}
+	return time.Unix(claims.ExpiresAt, 0), nil
+}
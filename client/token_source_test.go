@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"google.golang.org/grpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAuthService issues numbered tokens that expire after expiresIn. While
+// gate is set, Login and Refresh announce themselves on started and wait
+// for gate to be closed.
+type fakeAuthService struct {
+	pb.AuthServiceClient
+	mutex      sync.Mutex
+	issued     int
+	logins     int
+	refreshes  int
+	expiresIn  time.Duration
+	refreshErr error
+	gate       chan struct{}
+	started    chan struct{}
+}
+
+func newFakeAuthService() *fakeAuthService {
+	return &fakeAuthService{expiresIn: time.Hour, started: make(chan struct{}, 100)}
+}
+
+func (service *fakeAuthService) Login(ctx context.Context, req *pb.LoginRequest, opts ...grpc.CallOption) (*pb.LoginResponse, error) {
+	service.mutex.Lock()
+	service.logins++
+	service.mutex.Unlock()
+
+	accessToken := service.issue()
+	return &pb.LoginResponse{AccessToken: accessToken, RefreshToken: "refresh-" + accessToken}, nil
+}
+
+func (service *fakeAuthService) Refresh(ctx context.Context, req *pb.RefreshRequest, opts ...grpc.CallOption) (*pb.RefreshResponse, error) {
+	service.mutex.Lock()
+	service.refreshes++
+	err := service.refreshErr
+	service.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	accessToken := service.issue()
+	return &pb.RefreshResponse{AccessToken: accessToken, RefreshToken: "refresh-" + accessToken}, nil
+}
+
+func (service *fakeAuthService) issue() string {
+	service.mutex.Lock()
+	gate := service.gate
+	service.mutex.Unlock()
+	if gate != nil {
+		service.started <- struct{}{}
+		<-gate
+	}
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.issued++
+	return testToken(service.issued, time.Now().Add(service.expiresIn))
+}
+
+func (service *fakeAuthService) block() chan struct{} {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.gate = make(chan struct{})
+	return service.gate
+}
+
+func (service *fakeAuthService) calls() (int, int) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	return service.logins, service.refreshes
+}
+
+// testToken builds an unsigned JWT, which is all the token source reads.
+func testToken(id int, expiresAt time.Time) string {
+	payload := fmt.Sprintf(`{"jti":"%d","exp":%d}`, id, expiresAt.Unix())
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+func newTestTokenSource(t *testing.T, service *fakeAuthService, opts ...AuthClientOption) (*TokenSource, *AuthClient) {
+	t.Helper()
+
+	authClient := &AuthClient{service: service, username: "alice", password: "secret"}
+	for _, opt := range opts {
+		opt(authClient)
+	}
+	source, err := NewTokenSource(authClient, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(source.Close)
+	return source, authClient
+}
+
+func TestTokenSourceLogsInOnceForConcurrentRelogins(t *testing.T) {
+	service := newFakeAuthService()
+	source, _ := newTestTokenSource(t, service, WithRelogin())
+	stale := source.Token()
+
+	gate := service.block()
+	results := make([]string, 10)
+	errs := make([]error, len(results))
+	var wait sync.WaitGroup
+	for i := range results {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			if i%2 == 0 {
+				results[i], errs[i] = source.Relogin(stale)
+			} else {
+				results[i] = source.Token()
+			}
+		}(i)
+	}
+
+	<-service.started
+	time.Sleep(20 * time.Millisecond)
+	close(gate)
+	wait.Wait()
+
+	if logins, _ := service.calls(); logins != 2 {
+		t.Fatalf("Login() called %d times, want the initial login and one relogin", logins)
+	}
+	fresh := source.Token()
+	for i := 0; i < len(results); i += 2 {
+		if errs[i] != nil || results[i] != fresh || fresh == stale {
+			t.Errorf("Relogin() #%d = %q, %v, want the single new token %q", i, results[i], errs[i], fresh)
+		}
+	}
+}
+
+func TestTokenSourceCoalescesRenewals(t *testing.T) {
+	service := newFakeAuthService()
+	source, _ := newTestTokenSource(t, service, WithRelogin())
+	stale := source.Token()
+
+	gate := service.block()
+	renewed := make(chan error, 1)
+	go func() {
+		renewed <- source.renew(stale, false)
+	}()
+	<-service.started
+
+	relogins := make(chan error, 5)
+	for i := 0; i < cap(relogins); i++ {
+		go func() {
+			_, err := source.Relogin(stale)
+			relogins <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(gate)
+
+	if err := <-renewed; err != nil {
+		t.Fatalf("renew() error = %v", err)
+	}
+	for i := 0; i < cap(relogins); i++ {
+		if err := <-relogins; err != nil {
+			t.Fatalf("Relogin() error = %v", err)
+		}
+	}
+	if logins, refreshes := service.calls(); logins != 1 || refreshes != 1 {
+		t.Fatalf("Login() and Refresh() called %d and %d times, want relogins to share the refresh", logins, refreshes)
+	}
+}
+
+func TestTokenSourceIgnoresStaleRelogin(t *testing.T) {
+	service := newFakeAuthService()
+	source, _ := newTestTokenSource(t, service, WithRelogin())
+	stale := source.Token()
+
+	fresh, err := source.Relogin(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := source.Relogin(stale)
+	if err != nil {
+		t.Fatalf("Relogin() of a replaced token error = %v", err)
+	}
+	if again != fresh {
+		t.Fatal("expected a relogin of a replaced token to keep the newer token")
+	}
+	if logins, _ := service.calls(); logins != 2 {
+		t.Fatalf("Login() called %d times, want 2", logins)
+	}
+}
+
+func TestTokenSourceDiscardsPasswordWithoutRelogin(t *testing.T) {
+	service := newFakeAuthService()
+	source, authClient := newTestTokenSource(t, service)
+	if authClient.CanLogin() || authClient.password != "" {
+		t.Fatal("expected the password to be discarded after the first login")
+	}
+
+	if _, err := source.Relogin(source.Token()); err != nil {
+		t.Fatalf("Relogin() error = %v", err)
+	}
+	if logins, refreshes := service.calls(); logins != 1 || refreshes != 1 {
+		t.Fatalf("Login() and Refresh() called %d and %d times, want a relogin by refresh token", logins, refreshes)
+	}
+
+	service.mutex.Lock()
+	service.refreshErr = errors.New("refresh token revoked")
+	service.mutex.Unlock()
+	if _, err := source.Relogin(source.Token()); !errors.Is(err, errPasswordDiscarded) {
+		t.Fatalf("Relogin() without refresh token error = %v, want %v", err, errPasswordDiscarded)
+	}
+}
+
+func TestTokenSourceCloseStopsRenewal(t *testing.T) {
+	service := newFakeAuthService()
+	source, _ := newTestTokenSource(t, service)
+
+	closed := make(chan struct{})
+	go func() {
+		source.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close() to stop the renewal goroutine")
+	}
+
+	// Close is also registered as a cleanup, so a second call must not panic.
+	source.Close()
+}
+
+func TestTokenSourceNextRenewalJitter(t *testing.T) {
+	source := &TokenSource{refreshAhead: 2 * time.Minute, expiresAt: time.Now().Add(10 * time.Minute)}
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 50; i++ {
+		wait := source.nextRenewal()
+		if wait < 7*time.Minute-time.Second || wait > 8*time.Minute {
+			t.Fatalf("nextRenewal() = %v, want between 7m and 8m", wait)
+		}
+		seen[wait.Round(time.Second)] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("expected renewals to be spread by jitter")
+	}
+
+	source.expiresAt = time.Now().Add(time.Minute)
+	if wait := source.nextRenewal(); wait != renewRetryDelay {
+		t.Fatalf("nextRenewal() of a nearly expired token = %v, want %v", wait, renewRetryDelay)
+	}
+	source.renewal = &tokenRenewal{}
+	source.expiresAt = time.Now().Add(time.Hour)
+	if wait := source.nextRenewal(); wait != renewRetryDelay {
+		t.Fatalf("nextRenewal() during a renewal = %v, want %v", wait, renewRetryDelay)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	expiresAt := time.Unix(1700000000, 0)
+	got, err := tokenExpiry(testToken(1, expiresAt))
+	if err != nil || !got.Equal(expiresAt) {
+		t.Fatalf("tokenExpiry() = %v, %v, want %v", got, err, expiresAt)
+	}
+
+	noExp := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`)) + ".sig"
+	for _, invalid := range []string{"", "not-a-jwt", "a.!!!.c", noExp} {
+		if _, err := tokenExpiry(invalid); err == nil {
+			t.Errorf("tokenExpiry(%q) error = nil, want an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// Client is a backend service that obtains tokens with the client-credentials
+// grant. Secrets are generated with enough entropy that a plain hash is safe
+// to store.
+type Client struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	HashedSecret string   `json:"hashed_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+func NewClient(id string, secret string, name string, scopes []string) *Client {
+	return &Client{
+		ID:           id,
+		Name:         name,
+		HashedSecret: hashClientSecret(secret),
+		Scopes:       scopes,
+	}
+}
+
+func (client *Client) IsCorrectSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(client.HashedSecret), []byte(hashClientSecret(secret))) == 1
+}
+
+func (client *Client) Clone() *Client {
+	return &Client{
+		ID:           client.ID,
+		Name:         client.Name,
+		HashedSecret: client.HashedSecret,
+		Scopes:       append([]string(nil), client.Scopes...),
+	}
+}
+
+type ClientStore interface {
+	Save(client *Client) error
+	Find(id string) (*Client, error)
+}
+
+func hashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
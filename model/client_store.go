@@ -0,0 +1,43 @@
+package model
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrClientAlreadyExists = errors.New("client already exists")
+
+type InMemoryClientStore struct {
+	mutex   sync.RWMutex
+	clients map[string]*Client
+}
+
+func (store *InMemoryClientStore) Save(client *Client) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.clients[client.ID] != nil {
+		return ErrClientAlreadyExists
+	}
+
+	store.clients[client.ID] = client.Clone()
+	return nil
+}
+
+func (store *InMemoryClientStore) Find(id string) (*Client, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	client := store.clients[id]
+	if client == nil {
+		return nil, nil
+	}
+
+	return client.Clone(), nil
+}
+
+func NewInMemoryClientStore() *InMemoryClientStore {
+	return &InMemoryClientStore{
+		clients: make(map[string]*Client),
+	}
+}
@@ -0,0 +1,13 @@
+package model_test
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	"github.com/simp7/pracgrpc/model/storetest"
+	"testing"
+)
+
+func TestInMemoryClientStore(t *testing.T) {
+	storetest.TestClientStore(t, func(t *testing.T) model.ClientStore {
+		return model.NewInMemoryClientStore()
+	})
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileClientStore keeps clients in memory and writes all of them to a JSON
+// file whenever one is registered. Clients are few and rarely added, so the
+// whole file is rewritten instead of appended to.
+type FileClientStore struct {
+	mutex  sync.Mutex
+	path   string
+	memory *InMemoryClientStore
+}
+
+func NewFileClientStore(path string) (*FileClientStore, error) {
+	store := &FileClientStore{
+		path:   path,
+		memory: NewInMemoryClientStore(),
+	}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileClientStore) Save(client *Client) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.memory.Save(client); err != nil {
+		return err
+	}
+	if err := store.write(); err != nil {
+		store.memory.mutex.Lock()
+		delete(store.memory.clients, client.ID)
+		store.memory.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (store *FileClientStore) Find(id string) (*Client, error) {
+	return store.memory.Find(id)
+}
+
+func (store *FileClientStore) load() error {
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read client store: %w", err)
+	}
+
+	var clients []*Client
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return fmt.Errorf("cannot parse client store: %w", err)
+	}
+	for _, client := range clients {
+		store.memory.clients[client.ID] = client
+	}
+	return nil
+}
+
+// write replaces the file with the current clients, swapping it in atomically
+// so a crash never leaves half of it behind.
+func (store *FileClientStore) write() error {
+	store.memory.mutex.RLock()
+	clients := make([]*Client, 0, len(store.memory.clients))
+	for _, client := range store.memory.clients {
+		clients = append(clients, client)
+	}
+	store.memory.mutex.RUnlock()
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+
+	data, err := json.Marshal(clients)
+	if err != nil {
+		return fmt.Errorf("cannot encode clients: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(store.path), ".clients-*")
+	if err != nil {
+		return fmt.Errorf("cannot create client store: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot protect client store: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write client store: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write client store: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("cannot write client store: %w", err)
+	}
+	return os.Rename(file.Name(), store.path)
+}
@@ -0,0 +1,32 @@
+package model_test
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	"github.com/simp7/pracgrpc/model/storetest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileClientStore(t *testing.T) {
+	var path string
+
+	open := func(t *testing.T) model.ClientStore {
+		path = filepath.Join(t.TempDir(), "users.json.clients")
+		return openFileClientStore(t, path)
+	}
+	reopen := func(t *testing.T) model.ClientStore {
+		return openFileClientStore(t, path)
+	}
+
+	storetest.TestDurableClientStore(t, open, reopen)
+}
+
+func openFileClientStore(t *testing.T, path string) *model.FileClientStore {
+	t.Helper()
+
+	store, err := model.NewFileClientStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
@@ -0,0 +1,276 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const fileSchemaVersion = 1
+
+// fileMigrations upgrade raw records one schema version at a time: the entry
+// at index i turns a record of version i+1 into one of version i+2.
+var fileMigrations []func(record map[string]interface{}) error
+
+type fileHeader struct {
+	Schema int `json:"schema"`
+}
+
+type fileRecord struct {
+	Op       string `json:"op"`
+	User     *User  `json:"user,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+type FileUserStore struct {
+	mutex  sync.Mutex
+	path   string
+	file   *os.File
+	memory *InMemoryUserStore
+}
+
+func NewFileUserStore(path string) (*FileUserStore, error) {
+	store := &FileUserStore{
+		path:   path,
+		memory: NewInMemoryUserStore(),
+	}
+
+	if err := store.load(); err != nil {
+		if store.file != nil {
+			store.file.Close()
+		}
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileUserStore) Save(user *User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	existing, _ := store.memory.Find(user.Username)
+	if existing != nil {
+		return ErrAlreadyExists
+	}
+
+	if err := store.append(fileRecord{Op: "put", User: user}); err != nil {
+		return err
+	}
+	return store.memory.Save(user)
+}
+
+func (store *FileUserStore) Find(username string) (*User, error) {
+	return store.memory.Find(username)
+}
+
+func (store *FileUserStore) Update(user *User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	existing, _ := store.memory.Find(user.Username)
+	if existing == nil {
+		return ErrNotFound
+	}
+
+	if err := store.append(fileRecord{Op: "put", User: user}); err != nil {
+		return err
+	}
+	return store.memory.Update(user)
+}
+
+func (store *FileUserStore) Delete(username string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	existing, _ := store.memory.Find(username)
+	if existing == nil {
+		return ErrNotFound
+	}
+
+	if err := store.append(fileRecord{Op: "delete", Username: username}); err != nil {
+		return err
+	}
+	return store.memory.Delete(username)
+}
+
+func (store *FileUserStore) List(after string, limit int) ([]*User, error) {
+	return store.memory.List(after, limit)
+}
+
+// Compact rewrites the log so that it holds a single record per user.
+func (store *FileUserStore) Compact() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.compact()
+}
+
+func (store *FileUserStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.file.Close()
+}
+
+func (store *FileUserStore) load() error {
+	file, err := os.OpenFile(store.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot open user store: %w", err)
+	}
+	store.file = file
+
+	reader := bufio.NewReader(file)
+	schema := 0
+	offset := int64(0)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			// The last write was interrupted, so drop the partial record.
+			if err := file.Truncate(offset); err != nil {
+				return fmt.Errorf("cannot truncate user store: %w", err)
+			}
+			break
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read user store: %w", err)
+		}
+		offset += int64(len(line))
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if schema == 0 {
+			var header fileHeader
+			if err := json.Unmarshal(line, &header); err != nil || header.Schema == 0 {
+				return fmt.Errorf("user store %s has no schema header", store.path)
+			}
+			if header.Schema > fileSchemaVersion {
+				return fmt.Errorf("user store schema %d is newer than supported %d", header.Schema, fileSchemaVersion)
+			}
+			schema = header.Schema
+			continue
+		}
+
+		if err := store.replay(line, schema); err != nil {
+			return err
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("cannot seek user store: %w", err)
+	}
+
+	if schema == 0 {
+		return store.writeHeader()
+	}
+	if schema < fileSchemaVersion {
+		return store.compact()
+	}
+	return nil
+}
+
+func (store *FileUserStore) replay(line []byte, schema int) error {
+	if schema < fileSchemaVersion {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(line, &raw); err != nil {
+			return fmt.Errorf("cannot decode user record: %w", err)
+		}
+		for version := schema; version < fileSchemaVersion; version++ {
+			if err := fileMigrations[version-1](raw); err != nil {
+				return fmt.Errorf("cannot migrate user record to schema %d: %w", version+1, err)
+			}
+		}
+
+		migrated, err := json.Marshal(raw)
+		if err != nil {
+			return fmt.Errorf("cannot encode user record: %w", err)
+		}
+		line = migrated
+	}
+
+	var record fileRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return fmt.Errorf("cannot decode user record: %w", err)
+	}
+
+	switch record.Op {
+	case "put":
+		if record.User == nil {
+			return fmt.Errorf("user record has no user")
+		}
+		store.memory.users[record.User.Username] = record.User
+	case "delete":
+		delete(store.memory.users, record.Username)
+	default:
+		return fmt.Errorf("unknown user record operation: %s", record.Op)
+	}
+	return nil
+}
+
+func (store *FileUserStore) writeHeader() error {
+	header, err := json.Marshal(fileHeader{Schema: fileSchemaVersion})
+	if err != nil {
+		return err
+	}
+
+	if _, err := store.file.Write(append(header, '\n')); err != nil {
+		return fmt.Errorf("cannot write user store header: %w", err)
+	}
+	return store.file.Sync()
+}
+
+func (store *FileUserStore) append(record fileRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("cannot encode user record: %w", err)
+	}
+
+	if _, err := store.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("cannot write user record: %w", err)
+	}
+	return store.file.Sync()
+}
+
+func (store *FileUserStore) compact() error {
+	temp := store.path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot create user store: %w", err)
+	}
+
+	previous := store.file
+	store.file = file
+
+	users, _ := store.memory.List("", 0)
+	err = store.writeHeader()
+	for _, user := range users {
+		if err != nil {
+			break
+		}
+		err = store.append(fileRecord{Op: "put", User: user})
+	}
+
+	if err == nil {
+		err = os.Rename(temp, store.path)
+	}
+	if err != nil {
+		store.file = previous
+		file.Close()
+		os.Remove(temp)
+		return fmt.Errorf("cannot compact user store: %w", err)
+	}
+
+	previous.Close()
+	return nil
+}
@@ -0,0 +1,39 @@
+package model_test
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	"github.com/simp7/pracgrpc/model/storetest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileUserStore(t *testing.T) {
+	var path string
+	var current *model.FileUserStore
+
+	open := func(t *testing.T) model.UserStore {
+		path = filepath.Join(t.TempDir(), "users.json")
+		current = openFileUserStore(t, path)
+		return current
+	}
+	reopen := func(t *testing.T) model.UserStore {
+		if err := current.Close(); err != nil {
+			t.Fatal(err)
+		}
+		current = openFileUserStore(t, path)
+		return current
+	}
+
+	storetest.TestDurableUserStore(t, open, reopen)
+}
+
+func openFileUserStore(t *testing.T, path string) *model.FileUserStore {
+	t.Helper()
+
+	store, err := model.NewFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
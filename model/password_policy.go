@@ -0,0 +1,97 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// maxPasswordLength is the number of bytes bcrypt can hash.
+const maxPasswordLength = 72
+
+// PasswordPolicy decides which passwords users may choose. MinClasses counts
+// how many of lower case letters, upper case letters, digits and symbols a
+// password has to mix.
+type PasswordPolicy struct {
+	MinLength  int
+	MinClasses int
+	denylist   map[string]bool
+}
+
+func NewPasswordPolicy(minLength int, minClasses int) *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:  minLength,
+		MinClasses: minClasses,
+		denylist:   make(map[string]bool),
+	}
+}
+
+// LoadDenylist adds every non-empty line of path to the passwords that are
+// rejected regardless of their strength. Lines starting with # are ignored.
+func (policy *PasswordPolicy) LoadDenylist(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open password denylist: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.denylist[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read password denylist: %w", err)
+	}
+	return nil
+}
+
+func (policy *PasswordPolicy) Validate(username string, password string) error {
+	if len([]rune(password)) < policy.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", policy.MinLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes long", maxPasswordLength)
+	}
+	if classes := characterClasses(password); classes < policy.MinClasses {
+		return fmt.Errorf("password must mix at least %d of lower case, upper case, digits and symbols", policy.MinClasses)
+	}
+
+	lowered := strings.ToLower(password)
+	if policy.denylist[lowered] {
+		return fmt.Errorf("password is too common")
+	}
+	if username != "" && strings.Contains(lowered, strings.ToLower(username)) {
+		return fmt.Errorf("password must not contain the username")
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
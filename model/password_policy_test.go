@@ -0,0 +1,69 @@
+package model_test
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	if err := os.WriteFile(path, []byte("# common passwords\n\nPassw0rd!\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := model.NewPasswordPolicy(8, 3)
+	if err := policy.LoadDenylist(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"Sh0rt!", false},
+		{"lowercaseonly", false},
+		{"lower and UPPER", true},
+		{"lower1and2digits", false},
+		{"Lower1and2digits", true},
+		{"passw0rd!", false},
+		{"Alice-in-Wonderland1", false},
+		{strings.Repeat("Aa1", 25), false},
+		{"Ünïcode-Pässwort", true},
+	}
+	for _, test := range tests {
+		err := policy.Validate("alice", test.password)
+		if (err == nil) != test.valid {
+			t.Errorf("Validate(%q) error = %v, want valid %v", test.password, err, test.valid)
+		}
+	}
+}
+
+func TestPasswordPolicyMissingDenylist(t *testing.T) {
+	policy := model.NewPasswordPolicy(8, 1)
+	if err := policy.LoadDenylist(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Fatal("expected a missing denylist to fail")
+	}
+}
+
+func TestUserNeedsRehash(t *testing.T) {
+	user, err := model.NewUser("alice", "secret", "user", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.NeedsRehash(bcrypt.MinCost) {
+		t.Fatal("expected a hash of the current cost to be kept")
+	}
+	if !user.NeedsRehash(bcrypt.MinCost + 1) {
+		t.Fatal("expected a hash below the current cost to be rehashed")
+	}
+
+	user.HashedPassword = "not a bcrypt hash"
+	if !user.NeedsRehash(bcrypt.MinCost) {
+		t.Fatal("expected an unreadable hash to be rehashed")
+	}
+}
@@ -0,0 +1,264 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// Policy maps full gRPC method names to the scopes that grant access to them.
+// A caller needs any one of the listed scopes, and an empty list admits every
+// authenticated caller. A pattern is either an exact method such as
+// /ecommerce.ProductInfo/addProduct, every method of a service such as
+// /ecommerce.OrderManagement/*, or * for every method. The most specific
+// pattern wins.
+//
+// Methods matched by Public need no credentials. Default decides what happens
+// to a method matched by neither Public nor Rules.
+//
+// Roles name the scopes granted to users holding them, including every scope
+// of the roles they inherit.
+//
+// Methods matched by ImpersonationBlocked cannot be called with a token an
+// admin obtained to act as another user.
+type Policy struct {
+	Default              string              `json:"default" yaml:"default"`
+	Public               []string            `json:"public" yaml:"public"`
+	Roles                map[string]Role     `json:"roles" yaml:"roles"`
+	Rules                map[string][]string `json:"rules" yaml:"rules"`
+	ImpersonationBlocked []string            `json:"impersonation_blocked" yaml:"impersonation_blocked"`
+	grants               map[string][]string
+}
+
+type Role struct {
+	Inherits []string `json:"inherits" yaml:"inherits"`
+	Scopes   []string `json:"scopes" yaml:"scopes"`
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policy: %w", err)
+	}
+
+	policy := &Policy{}
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(data, policy)
+	} else {
+		err = yaml.Unmarshal(data, policy)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse policy %s: %w", path, err)
+	}
+
+	if policy.Default != "" && policy.Default != PolicyAllow && policy.Default != PolicyDeny {
+		return nil, fmt.Errorf("invalid policy default: %s", policy.Default)
+	}
+
+	for _, pattern := range policy.patterns() {
+		if err := validatePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range policy.Public {
+		if _, ok := policy.Rules[pattern]; ok {
+			return nil, fmt.Errorf("policy pattern %s is both public and restricted", pattern)
+		}
+	}
+
+	if err := policy.resolveRoles(); err != nil {
+		return nil, err
+	}
+	for pattern, scopes := range policy.Rules {
+		for _, scope := range scopes {
+			if !policy.granted(scope) {
+				return nil, fmt.Errorf("policy rule %s requires scope %s that no role grants", pattern, scope)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Scopes returns the scopes that grant access to method and whether any rule
+// matches it.
+func (policy *Policy) Scopes(method string) ([]string, bool) {
+	for _, pattern := range candidatePatterns(method) {
+		if roles, ok := policy.Rules[pattern]; ok {
+			return roles, true
+		}
+	}
+	return nil, false
+}
+
+// IsPublic reports whether method is public, unless a more specific rule
+// restricts it.
+func (policy *Policy) IsPublic(method string) bool {
+	for _, pattern := range candidatePatterns(method) {
+		if _, ok := policy.Rules[pattern]; ok {
+			return false
+		}
+		for _, public := range policy.Public {
+			if public == pattern {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (policy *Policy) BlocksImpersonation(method string) bool {
+	for _, pattern := range candidatePatterns(method) {
+		for _, blocked := range policy.ImpersonationBlocked {
+			if blocked == pattern {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (policy *Policy) DenyByDefault() bool {
+	return policy.Default == PolicyDeny
+}
+
+// RoleScopes returns every scope granted to role, including inherited ones.
+func (policy *Policy) RoleScopes(role string) ([]string, bool) {
+	scopes, ok := policy.grants[role]
+	return scopes, ok
+}
+
+// Uncovered lists the registered methods that are neither public nor matched
+// by a rule.
+func (policy *Policy) Uncovered(services map[string]grpc.ServiceInfo) []string {
+	var methods []string
+	for service, info := range services {
+		for _, method := range info.Methods {
+			fullMethod := "/" + service + "/" + method.Name
+			if _, ok := policy.Scopes(fullMethod); !ok && !policy.IsPublic(fullMethod) {
+				methods = append(methods, fullMethod)
+			}
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Validate reports patterns that do not match any method of services, which
+// usually means a typo that would otherwise leave a method unprotected.
+func (policy *Policy) Validate(services map[string]grpc.ServiceInfo) error {
+	methods := make(map[string]bool)
+	for service, info := range services {
+		methods["/"+service+"/*"] = true
+		for _, method := range info.Methods {
+			methods["/"+service+"/"+method.Name] = true
+		}
+	}
+
+	for _, pattern := range policy.patterns() {
+		if pattern != "*" && !methods[pattern] {
+			return fmt.Errorf("policy rule %s matches no registered method", pattern)
+		}
+	}
+	return nil
+}
+
+func (policy *Policy) resolveRoles() error {
+	policy.grants = make(map[string][]string)
+	for name := range policy.Roles {
+		scopes, err := policy.collectScopes(name, make(map[string]bool))
+		if err != nil {
+			return err
+		}
+
+		unique := make(map[string]bool)
+		for _, scope := range scopes {
+			unique[scope] = true
+		}
+		policy.grants[name] = sortedKeys(unique)
+	}
+	return nil
+}
+
+func (policy *Policy) collectScopes(name string, visiting map[string]bool) ([]string, error) {
+	role, ok := policy.Roles[name]
+	if !ok {
+		return nil, fmt.Errorf("policy role %s is not defined", name)
+	}
+	if visiting[name] {
+		return nil, fmt.Errorf("policy role %s inherits itself", name)
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	scopes := append([]string(nil), role.Scopes...)
+	for _, parent := range role.Inherits {
+		inherited, err := policy.collectScopes(parent, visiting)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, inherited...)
+	}
+	return scopes, nil
+}
+
+func (policy *Policy) granted(scope string) bool {
+	for _, role := range policy.Roles {
+		for _, granted := range role.Scopes {
+			if granted == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (policy *Policy) patterns() []string {
+	patterns := append([]string(nil), policy.Public...)
+	patterns = append(patterns, policy.ImpersonationBlocked...)
+	for pattern := range policy.Rules {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+func candidatePatterns(method string) []string {
+	candidates := []string{method}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		candidates = append(candidates, method[:i]+"/*")
+	}
+	return append(candidates, "*")
+}
+
+func validatePattern(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+
+	parts := strings.Split(pattern, "/")
+	if len(parts) != 3 || parts[0] != "" || parts[1] == "" || parts[2] == "" {
+		return fmt.Errorf("invalid policy pattern: %s", pattern)
+	}
+	if strings.Contains(parts[1], "*") || (parts[2] != "*" && strings.Contains(parts[2], "*")) {
+		return fmt.Errorf("invalid wildcard in policy pattern: %s", pattern)
+	}
+	return nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
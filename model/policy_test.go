@@ -0,0 +1,195 @@
+package model_test
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	"google.golang.org/grpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPolicyScopesPrecedence(t *testing.T) {
+	policy := &model.Policy{Rules: map[string][]string{
+		"*":                                   {"server:admin"},
+		"/ecommerce.UserAdmin/*":              {"user:admin"},
+		"/ecommerce.UserAdmin/ChangePassword": {"password:change", "user:admin"},
+	}}
+
+	tests := map[string]int{
+		"/ecommerce.UserAdmin/ChangePassword": 2,
+		"/ecommerce.UserAdmin/DeleteUser":     1,
+		"/ecommerce.ProductInfo/getProduct":   1,
+	}
+	for method, want := range tests {
+		scopes, ok := policy.Scopes(method)
+		if !ok || len(scopes) != want {
+			t.Errorf("Scopes(%s) = %v, %v, want %d scopes", method, scopes, ok, want)
+		}
+	}
+
+	if _, ok := (&model.Policy{}).Scopes("/ecommerce.ProductInfo/getProduct"); ok {
+		t.Fatal("expected an empty policy to cover no method")
+	}
+}
+
+const testRoles = "roles:\n  admin:\n    scopes: [product:write]\n"
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		file    string
+		content string
+		wantErr bool
+	}{
+		{"policy.yaml", testRoles + "rules:\n  /ecommerce.ProductInfo/addProduct: [product:write]\n", false},
+		{"policy.json", `{"roles": {"admin": {"scopes": ["product:write"]}}, "rules": {"/ecommerce.ProductInfo/*": ["product:write"]}}`, false},
+		{"catch-all.yaml", testRoles + "rules:\n  \"*\": [product:write]\n", false},
+		{"no-method.yaml", testRoles + "rules:\n  /ecommerce.ProductInfo: [product:write]\n", true},
+		{"service-wildcard.yaml", testRoles + "rules:\n  /ecommerce.*/addProduct: [product:write]\n", true},
+		{"partial-wildcard.yaml", testRoles + "rules:\n  /ecommerce.ProductInfo/add*: [product:write]\n", true},
+		{"broken.json", `{"rules": `, true},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.file)
+		if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := model.LoadPolicy(path); (err != nil) != test.wantErr {
+			t.Errorf("LoadPolicy(%s) error = %v, want error %v", test.file, err, test.wantErr)
+		}
+	}
+
+	if _, err := model.LoadPolicy(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Fatal("expected a missing policy to fail")
+	}
+}
+
+func TestPolicyPublicAndDefault(t *testing.T) {
+	policy := &model.Policy{
+		Default: model.PolicyDeny,
+		Public:  []string{"/ecommerce.AuthService/*"},
+		Rules: map[string][]string{
+			"/ecommerce.AuthService/Logout":     {},
+			"/ecommerce.ProductInfo/addProduct": {"product:write"},
+		},
+	}
+
+	tests := map[string]bool{
+		"/ecommerce.AuthService/Login":      true,
+		"/ecommerce.AuthService/Logout":     false,
+		"/ecommerce.ProductInfo/addProduct": false,
+		"/ecommerce.ProductInfo/getProduct": false,
+	}
+	for method, want := range tests {
+		if public := policy.IsPublic(method); public != want {
+			t.Errorf("IsPublic(%s) = %v, want %v", method, public, want)
+		}
+	}
+
+	policy.Default = model.PolicyAllow
+	if policy.DenyByDefault() {
+		t.Fatal("expected an allow policy not to deny by default")
+	}
+}
+
+func TestPolicyUncovered(t *testing.T) {
+	policy := &model.Policy{
+		Public: []string{"/ecommerce.AuthService/Login"},
+		Rules:  map[string][]string{"/ecommerce.ProductInfo/*": {"product:write"}},
+	}
+	services := map[string]grpc.ServiceInfo{
+		"ecommerce.ProductInfo": {Methods: []grpc.MethodInfo{{Name: "addProduct"}, {Name: "getProduct"}}},
+		"ecommerce.AuthService": {Methods: []grpc.MethodInfo{{Name: "Login"}, {Name: "Logout"}}},
+	}
+
+	uncovered := policy.Uncovered(services)
+	if len(uncovered) != 1 || uncovered[0] != "/ecommerce.AuthService/Logout" {
+		t.Fatalf("Uncovered() = %v, want only Logout", uncovered)
+	}
+}
+
+func TestLoadPolicyRejectsInvalidDefaults(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"default.yaml": "default: maybe\n",
+		"overlap.yaml": "public: [/ecommerce.AuthService/Login]\nrules:\n  /ecommerce.AuthService/Login: []\n",
+		"public.yaml":  "public: [/ecommerce.AuthService]\n",
+	}
+	for file, content := range tests {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := model.LoadPolicy(path); err == nil {
+			t.Errorf("LoadPolicy(%s) error = nil, want an error", file)
+		}
+	}
+}
+
+func TestLoadPolicyResolvesRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	content := "roles:\n" +
+		"  user:\n    scopes: [order:read, order:write]\n" +
+		"  auditor:\n    inherits: [user]\n    scopes: [order:read, log:read]\n" +
+		"  admin:\n    inherits: [auditor, user]\n    scopes: [order:admin]\n" +
+		"rules:\n  /ecommerce.OrderManagement/getOrder: [order:read]\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := model.LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+
+	tests := map[string][]string{
+		"user":    {"order:read", "order:write"},
+		"auditor": {"log:read", "order:read", "order:write"},
+		"admin":   {"log:read", "order:admin", "order:read", "order:write"},
+	}
+	for role, want := range tests {
+		scopes, ok := policy.RoleScopes(role)
+		if !ok || !reflect.DeepEqual(scopes, want) {
+			t.Errorf("RoleScopes(%s) = %v, %v, want %v", role, scopes, ok, want)
+		}
+	}
+	if _, ok := policy.RoleScopes("guest"); ok {
+		t.Fatal("expected an undefined role to grant no scopes")
+	}
+}
+
+func TestLoadPolicyRejectsInvalidRoles(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"cycle.yaml":     "roles:\n  user:\n    inherits: [admin]\n  admin:\n    inherits: [user]\n",
+		"self.yaml":      "roles:\n  user:\n    inherits: [user]\n",
+		"undefined.yaml": "roles:\n  admin:\n    inherits: [user]\n",
+		"ungranted.yaml": testRoles + "rules:\n  /ecommerce.ProductInfo/getProduct: [product:read]\n",
+	}
+	for file, content := range tests {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := model.LoadPolicy(path); err == nil {
+			t.Errorf("LoadPolicy(%s) error = nil, want an error", file)
+		}
+	}
+}
+
+func TestPolicyBlocksImpersonation(t *testing.T) {
+	policy := &model.Policy{ImpersonationBlocked: []string{"/ecommerce.UserAdmin/*", "/ecommerce.AuthService/RevokeSession"}}
+
+	tests := map[string]bool{
+		"/ecommerce.UserAdmin/DeleteUser":        true,
+		"/ecommerce.AuthService/RevokeSession":   true,
+		"/ecommerce.AuthService/ListSessions":    false,
+		"/ecommerce.OrderManagement/createOrder": false,
+	}
+	for method, want := range tests {
+		if blocked := policy.BlocksImpersonation(method); blocked != want {
+			t.Errorf("BlocksImpersonation(%s) = %v, want %v", method, blocked, want)
+		}
+	}
+}
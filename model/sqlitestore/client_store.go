@@ -0,0 +1,46 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/simp7/pracgrpc/model"
+	"strings"
+)
+
+// ClientStore keeps clients in the database of a UserStore.
+type ClientStore struct {
+	db *sql.DB
+}
+
+// Clients returns the client store sharing the database of store.
+func (store *UserStore) Clients() *ClientStore {
+	return &ClientStore{store.db}
+}
+
+func (store *ClientStore) Save(client *model.Client) error {
+	res, err := store.db.Exec(
+		`INSERT INTO clients (id, name, hashed_secret, scopes) VALUES (?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		client.ID, client.Name, client.HashedSecret, strings.Join(client.Scopes, ","),
+	)
+	if err != nil {
+		return fmt.Errorf("cannot insert client: %w", err)
+	}
+	return expectAffected(res, model.ErrClientAlreadyExists)
+}
+
+func (store *ClientStore) Find(id string) (*model.Client, error) {
+	client := &model.Client{}
+	var scopes string
+	err := store.db.QueryRow(`SELECT id, name, hashed_secret, scopes FROM clients WHERE id = ?`, id).
+		Scan(&client.ID, &client.Name, &client.HashedSecret, &scopes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot query client: %w", err)
+	}
+	if scopes != "" {
+		client.Scopes = strings.Split(scopes, ",")
+	}
+	return client, nil
+}
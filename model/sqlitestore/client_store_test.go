@@ -0,0 +1,29 @@
+package sqlitestore_test
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	"github.com/simp7/pracgrpc/model/sqlitestore"
+	"github.com/simp7/pracgrpc/model/storetest"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientStore(t *testing.T) {
+	var path string
+	var current *sqlitestore.UserStore
+
+	open := func(t *testing.T) model.ClientStore {
+		path = filepath.Join(t.TempDir(), "users.db")
+		current = openUserStore(t, path)
+		return current.Clients()
+	}
+	reopen := func(t *testing.T) model.ClientStore {
+		if err := current.Close(); err != nil {
+			t.Fatal(err)
+		}
+		current = openUserStore(t, path)
+		return current.Clients()
+	}
+
+	storetest.TestDurableClientStore(t, open, reopen)
+}
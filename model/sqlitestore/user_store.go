@@ -0,0 +1,199 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/simp7/pracgrpc/model"
+	_ "modernc.org/sqlite"
+	"strings"
+)
+
+// migrations are applied in order and recorded in schema_migrations, so an
+// entry must never change once it has been released.
+var migrations = []string{
+	`CREATE TABLE users (
+		username        TEXT PRIMARY KEY,
+		hashed_password TEXT NOT NULL,
+		role            TEXT NOT NULL
+	)`,
+	`CREATE TABLE clients (
+		id            TEXT PRIMARY KEY,
+		name          TEXT NOT NULL,
+		hashed_secret TEXT NOT NULL,
+		scopes        TEXT NOT NULL DEFAULT ''
+	)`,
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN recovery_codes TEXT NOT NULL DEFAULT ''`,
+}
+
+const userColumns = `username, hashed_password, role, totp_secret, totp_enabled, totp_last_step, recovery_codes`
+
+type UserStore struct {
+	db *sql.DB
+}
+
+func NewUserStore(path string) (*UserStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open user store: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	store := &UserStore{db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *UserStore) Save(user *model.User) error {
+	res, err := store.db.Exec(
+		`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (username) DO NOTHING`,
+		user.Username, user.HashedPassword, user.Role,
+		user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, strings.Join(user.RecoveryCodes, ","),
+	)
+	if err != nil {
+		return fmt.Errorf("cannot insert user: %w", err)
+	}
+	return expectAffected(res, model.ErrAlreadyExists)
+}
+
+func (store *UserStore) Find(username string) (*model.User, error) {
+	row := store.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username)
+
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot query user: %w", err)
+	}
+	return user, nil
+}
+
+func (store *UserStore) Update(user *model.User) error {
+	res, err := store.db.Exec(
+		`UPDATE users SET hashed_password = ?, role = ?, totp_secret = ?, totp_enabled = ?, totp_last_step = ?, recovery_codes = ?
+		WHERE username = ?`,
+		user.HashedPassword, user.Role,
+		user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, strings.Join(user.RecoveryCodes, ","),
+		user.Username,
+	)
+	if err != nil {
+		return fmt.Errorf("cannot update user: %w", err)
+	}
+	return expectAffected(res, model.ErrNotFound)
+}
+
+func (store *UserStore) Delete(username string) error {
+	res, err := store.db.Exec(`DELETE FROM users WHERE username = ?`, username)
+	if err != nil {
+		return fmt.Errorf("cannot delete user: %w", err)
+	}
+	return expectAffected(res, model.ErrNotFound)
+}
+
+func (store *UserStore) List(after string, limit int) ([]*model.User, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := store.db.Query(
+		`SELECT `+userColumns+` FROM users WHERE username > ? ORDER BY username LIMIT ?`,
+		after, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]*model.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("cannot scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (store *UserStore) Close() error {
+	return store.db.Close()
+}
+
+func (store *UserStore) migrate() error {
+	_, err := store.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("cannot create migration table: %w", err)
+	}
+
+	var current int
+	err = store.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("cannot read schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("user store schema %d is newer than supported %d", current, len(migrations))
+	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		if err := store.apply(version, migrations[version-1]); err != nil {
+			return fmt.Errorf("cannot migrate user store to schema %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func (store *UserStore) apply(version int, migration string) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(migration); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*model.User, error) {
+	user := &model.User{}
+	var recoveryCodes string
+	err := row.Scan(
+		&user.Username, &user.HashedPassword, &user.Role,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep, &recoveryCodes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if recoveryCodes != "" {
+		user.RecoveryCodes = strings.Split(recoveryCodes, ",")
+	}
+	return user, nil
+}
+
+func expectAffected(res sql.Result, errNone error) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cannot read affected rows: %w", err)
+	}
+	if affected == 0 {
+		return errNone
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package sqlitestore_test
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	"github.com/simp7/pracgrpc/model/sqlitestore"
+	"github.com/simp7/pracgrpc/model/storetest"
+	"path/filepath"
+	"testing"
+)
+
+func TestUserStore(t *testing.T) {
+	var path string
+	var current *sqlitestore.UserStore
+
+	open := func(t *testing.T) model.UserStore {
+		path = filepath.Join(t.TempDir(), "users.db")
+		current = openUserStore(t, path)
+		return current
+	}
+	reopen := func(t *testing.T) model.UserStore {
+		if err := current.Close(); err != nil {
+			t.Fatal(err)
+		}
+		current = openUserStore(t, path)
+		return current
+	}
+
+	storetest.TestDurableUserStore(t, open, reopen)
+}
+
+func openUserStore(t *testing.T, path string) *sqlitestore.UserStore {
+	t.Helper()
+
+	store, err := sqlitestore.NewUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
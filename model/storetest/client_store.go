@@ -0,0 +1,100 @@
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/simp7/pracgrpc/model"
+	"testing"
+)
+
+type ClientOpenFunc func(t *testing.T) model.ClientStore
+
+func TestClientStore(t *testing.T, open ClientOpenFunc) {
+	t.Run("SaveAndFind", func(t *testing.T) {
+		store := open(t)
+		client := model.NewClient("client-1", "secret", "billing", []string{"order:read", "order:write"})
+
+		if err := store.Save(client); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		found, err := store.Find("client-1")
+		if err != nil {
+			t.Fatalf("Find() error = %v", err)
+		}
+		assertClient(t, found, client)
+		if !found.IsCorrectSecret("secret") {
+			t.Fatalf("IsCorrectSecret() = false for the registered secret")
+		}
+	})
+
+	t.Run("SaveDuplicate", func(t *testing.T) {
+		store := open(t)
+		mustSaveClient(t, store, model.NewClient("client-1", "secret", "billing", nil))
+
+		err := store.Save(model.NewClient("client-1", "other", "shipping", nil))
+		if !errors.Is(err, model.ErrClientAlreadyExists) {
+			t.Fatalf("Save() error = %v, want %v", err, model.ErrClientAlreadyExists)
+		}
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		store := open(t)
+
+		found, err := store.Find("nobody")
+		if err != nil || found != nil {
+			t.Fatalf("Find() = %v, %v, want nil, nil", found, err)
+		}
+	})
+
+	t.Run("FindReturnsCopy", func(t *testing.T) {
+		store := open(t)
+		mustSaveClient(t, store, model.NewClient("client-1", "secret", "billing", []string{"order:read"}))
+
+		found, _ := store.Find("client-1")
+		found.Scopes[0] = "admin"
+
+		again, _ := store.Find("client-1")
+		if again.Scopes[0] != "order:read" {
+			t.Fatalf("Find() result aliases stored client")
+		}
+	})
+}
+
+// TestDurableClientStore checks that registered clients survive reopening the
+// store. reopen must close the previous store before opening the same location.
+func TestDurableClientStore(t *testing.T, open ClientOpenFunc, reopen ClientOpenFunc) {
+	TestClientStore(t, open)
+
+	t.Run("Reopen", func(t *testing.T) {
+		store := open(t)
+		client := model.NewClient("client-1", "secret", "billing", []string{"order:read"})
+		mustSaveClient(t, store, client)
+
+		store = reopen(t)
+		found, err := store.Find("client-1")
+		if err != nil {
+			t.Fatalf("Find() error = %v", err)
+		}
+		assertClient(t, found, client)
+	})
+}
+
+func mustSaveClient(t *testing.T, store model.ClientStore, client *model.Client) {
+	t.Helper()
+
+	if err := store.Save(client); err != nil {
+		t.Fatalf("Save(%s) error = %v", client.ID, err)
+	}
+}
+
+func assertClient(t *testing.T, got *model.Client, want *model.Client) {
+	t.Helper()
+
+	if got == nil {
+		t.Fatalf("client %s not found", want.ID)
+	}
+	if fmt.Sprint(*got) != fmt.Sprint(*want) {
+		t.Fatalf("client = %+v, want %+v", *got, *want)
+	}
+}
@@ -0,0 +1,223 @@
+// Package storetest holds the conformance suites every model.UserStore and
+// model.ClientStore implementation is expected to pass.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/simp7/pracgrpc/model"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+type OpenFunc func(t *testing.T) model.UserStore
+
+func TestUserStore(t *testing.T, open OpenFunc) {
+	t.Run("SaveAndFind", func(t *testing.T) {
+		store := open(t)
+		user := newUser(t, "alice", "admin")
+
+		if err := store.Save(user); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		found, err := store.Find("alice")
+		if err != nil {
+			t.Fatalf("Find() error = %v", err)
+		}
+		assertUser(t, found, user)
+	})
+
+	t.Run("SaveDuplicate", func(t *testing.T) {
+		store := open(t)
+		mustSave(t, store, newUser(t, "alice", "user"))
+
+		err := store.Save(newUser(t, "alice", "admin"))
+		if !errors.Is(err, model.ErrAlreadyExists) {
+			t.Fatalf("Save() error = %v, want %v", err, model.ErrAlreadyExists)
+		}
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		store := open(t)
+
+		found, err := store.Find("nobody")
+		if err != nil || found != nil {
+			t.Fatalf("Find() = %v, %v, want nil, nil", found, err)
+		}
+	})
+
+	t.Run("FindReturnsCopy", func(t *testing.T) {
+		store := open(t)
+		mustSave(t, store, newUser(t, "alice", "user"))
+
+		found, _ := store.Find("alice")
+		found.Role = "admin"
+
+		again, _ := store.Find("alice")
+		if again.Role != "user" {
+			t.Fatalf("Find() result aliases stored user")
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		store := open(t)
+		user := newUser(t, "alice", "user")
+		mustSave(t, store, user)
+
+		user.Role = "admin"
+		if err := user.SetPassword("changed", bcrypt.MinCost); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Update(user); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+
+		found, _ := store.Find("alice")
+		assertUser(t, found, user)
+		if !found.IsCorrectPassword("changed") {
+			t.Fatalf("Update() did not store the new password")
+		}
+	})
+
+	t.Run("UpdateSecondFactor", func(t *testing.T) {
+		store := open(t)
+		user := newUser(t, "alice", "user")
+		mustSave(t, store, user)
+
+		user.TOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+		user.TOTPEnabled = true
+		user.TOTPLastStep = 41152263
+		if _, err := user.ResetRecoveryCodes(); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Update(user); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+
+		found, _ := store.Find("alice")
+		assertUser(t, found, user)
+	})
+
+	t.Run("UpdateMissing", func(t *testing.T) {
+		store := open(t)
+
+		err := store.Update(newUser(t, "nobody", "user"))
+		if !errors.Is(err, model.ErrNotFound) {
+			t.Fatalf("Update() error = %v, want %v", err, model.ErrNotFound)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := open(t)
+		mustSave(t, store, newUser(t, "alice", "user"))
+
+		if err := store.Delete("alice"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+
+		found, _ := store.Find("alice")
+		if found != nil {
+			t.Fatalf("Find() after Delete() = %v, want nil", found)
+		}
+
+		err := store.Delete("alice")
+		if !errors.Is(err, model.ErrNotFound) {
+			t.Fatalf("Delete() error = %v, want %v", err, model.ErrNotFound)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		store := open(t)
+		for _, username := range []string{"carol", "alice", "erin", "bob", "dave"} {
+			mustSave(t, store, newUser(t, username, "user"))
+		}
+
+		assertUsernames(t, mustList(t, store, "", 0), "alice", "bob", "carol", "dave", "erin")
+		assertUsernames(t, mustList(t, store, "", 2), "alice", "bob")
+		assertUsernames(t, mustList(t, store, "bob", 2), "carol", "dave")
+		assertUsernames(t, mustList(t, store, "dave", 2), "erin")
+		assertUsernames(t, mustList(t, store, "erin", 2))
+	})
+}
+
+// TestDurableUserStore checks that writes survive reopening the store.
+// reopen must close the previous store before opening the same location.
+func TestDurableUserStore(t *testing.T, open OpenFunc, reopen OpenFunc) {
+	TestUserStore(t, open)
+
+	t.Run("Reopen", func(t *testing.T) {
+		store := open(t)
+		alice := newUser(t, "alice", "user")
+		mustSave(t, store, alice)
+		mustSave(t, store, newUser(t, "bob", "user"))
+		mustSave(t, store, newUser(t, "carol", "user"))
+
+		alice.Role = "admin"
+		if err := store.Update(alice); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete("bob"); err != nil {
+			t.Fatal(err)
+		}
+
+		store = reopen(t)
+		found, err := store.Find("alice")
+		if err != nil {
+			t.Fatalf("Find() error = %v", err)
+		}
+		assertUser(t, found, alice)
+		assertUsernames(t, mustList(t, store, "", 0), "alice", "carol")
+	})
+}
+
+func newUser(t *testing.T, username string, role string) *model.User {
+	t.Helper()
+
+	user, err := model.NewUser(username, "secret", role, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func mustSave(t *testing.T, store model.UserStore, user *model.User) {
+	t.Helper()
+
+	if err := store.Save(user); err != nil {
+		t.Fatalf("Save(%s) error = %v", user.Username, err)
+	}
+}
+
+func mustList(t *testing.T, store model.UserStore, after string, limit int) []*model.User {
+	t.Helper()
+
+	users, err := store.List(after, limit)
+	if err != nil {
+		t.Fatalf("List(%q, %d) error = %v", after, limit, err)
+	}
+	return users
+}
+
+func assertUser(t *testing.T, got *model.User, want *model.User) {
+	t.Helper()
+
+	if got == nil {
+		t.Fatalf("user %s not found", want.Username)
+	}
+	if fmt.Sprint(*got) != fmt.Sprint(*want) {
+		t.Fatalf("user = %+v, want %+v", *got, *want)
+	}
+}
+
+func assertUsernames(t *testing.T, users []*model.User, want ...string) {
+	t.Helper()
+
+	got := make([]string, 0, len(users))
+	for _, user := range users {
+		got = append(got, user.Username)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("usernames = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package model_test
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range tests {
+		code, err := model.TOTPCode(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", unix, code, want)
+		}
+	}
+
+	if _, err := model.TOTPCode("not base32!", time.Unix(59, 0)); err == nil {
+		t.Fatal("expected an invalid secret to be rejected")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	codeAt := func(at time.Time) string {
+		code, err := model.TOTPCode(rfcSecret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	user := &model.User{TOTPSecret: rfcSecret}
+	if user.VerifyTOTP(codeAt(now.Add(-time.Minute)), now) {
+		t.Fatal("expected a code two steps old to be rejected")
+	}
+	if !user.VerifyTOTP(codeAt(now.Add(-30*time.Second)), now) {
+		t.Fatal("expected a code of the previous step to be accepted")
+	}
+	if !user.VerifyTOTP(codeAt(now), now) {
+		t.Fatal("expected the current code to be accepted")
+	}
+	if user.VerifyTOTP(codeAt(now), now) {
+		t.Fatal("expected a used code to be rejected")
+	}
+	if user.VerifyTOTP(codeAt(now.Add(-30*time.Second)), now) {
+		t.Fatal("expected a code older than a used one to be rejected")
+	}
+
+	if (&model.User{}).VerifyTOTP(codeAt(now), now) {
+		t.Fatal("expected a user without secret to reject every code")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	user := &model.User{}
+	codes, err := user.ResetRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 || len(user.RecoveryCodes) != len(codes) {
+		t.Fatalf("ResetRecoveryCodes() = %d codes with %d hashes, want 10", len(codes), len(user.RecoveryCodes))
+	}
+	for i, code := range codes {
+		if user.RecoveryCodes[i] == code {
+			t.Fatal("expected recovery codes to be stored hashed")
+		}
+	}
+
+	normalized := " " + strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")) + " "
+	if !user.UseRecoveryCode(normalized) {
+		t.Fatal("expected a recovery code to be accepted regardless of case, dashes and spaces")
+	}
+	if user.UseRecoveryCode(codes[0]) {
+		t.Fatal("expected a used recovery code to be rejected")
+	}
+	if len(user.RecoveryCodes) != 9 {
+		t.Fatalf("RecoveryCodes = %d, want 9 left", len(user.RecoveryCodes))
+	}
+
+	if _, err := user.ResetRecoveryCodes(); err != nil {
+		t.Fatal(err)
+	}
+	if user.UseRecoveryCode(codes[1]) {
+		t.Fatal("expected a reset to invalidate the previous codes")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := model.TOTPURI("pracgrpc", "alice", rfcSecret)
+	for _, want := range []string{"otpauth://totp/pracgrpc:alice?", "secret=" + rfcSecret, "issuer=pracgrpc", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("TOTPURI() = %s, want it to contain %s", uri, want)
+		}
+	}
+}
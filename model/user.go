@@ -5,41 +5,68 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// User is an account that logs in with a password. TOTPSecret is set while a
+// second factor is being enrolled and becomes required once TOTPEnabled is.
 type User struct {
-	Username       string
-	HashedPassword string
-	Role           string
+	Username       string   `json:"username"`
+	HashedPassword string   `json:"hashed_password"`
+	Role           string   `json:"role"`
+	TOTPSecret     string   `json:"totp_secret,omitempty"`
+	TOTPEnabled    bool     `json:"totp_enabled,omitempty"`
+	TOTPLastStep   int64    `json:"totp_last_step,omitempty"`
+	RecoveryCodes  []string `json:"recovery_codes,omitempty"`
 }
 
-func NewUser(username string, password string, role string) (*User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, fmt.Errorf("cannot hash password: %w", err)
+func NewUser(username string, password string, role string, cost int) (*User, error) {
+	user := &User{
+		Username: username,
+		Role:     role,
 	}
 
-	user := &User{
-		Username:       username,
-		HashedPassword: string(hashedPassword),
-		Role:           role,
+	if err := user.SetPassword(password, cost); err != nil {
+		return nil, err
 	}
 
 	return user, nil
 }
 
+func (user *User) SetPassword(password string, cost int) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return fmt.Errorf("cannot hash password: %w", err)
+	}
+
+	user.HashedPassword = string(hashedPassword)
+	return nil
+}
+
 func (user *User) IsCorrectPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
 	return err == nil
 }
 
+// NeedsRehash reports whether the password hash is weaker than cost.
+func (user *User) NeedsRehash(cost int) bool {
+	current, err := bcrypt.Cost([]byte(user.HashedPassword))
+	return err != nil || current < cost
+}
+
 func (user *User) Clone() *User {
 	return &User{
 		Username:       user.Username,
 		HashedPassword: user.HashedPassword,
 		Role:           user.Role,
+		TOTPSecret:     user.TOTPSecret,
+		TOTPEnabled:    user.TOTPEnabled,
+		TOTPLastStep:   user.TOTPLastStep,
+		RecoveryCodes:  append([]string(nil), user.RecoveryCodes...),
 	}
 }
 
 type UserStore interface {
 	Save(user *User) error
 	Find(username string) (*User, error)
+	Update(user *User) error
+	Delete(username string) error
+	List(after string, limit int) ([]*User, error)
 }
@@ -2,11 +2,13 @@ package model
 
 import (
 	"errors"
+	"sort"
 	"sync"
 )
 
 var (
 	ErrAlreadyExists = errors.New("user already exist")
+	ErrNotFound      = errors.New("user not found")
 )
 
 type InMemoryUserStore struct {
@@ -38,6 +40,53 @@ func (store *InMemoryUserStore) Find(username string) (*User, error) {
 	return user.Clone(), nil
 }
 
+func (store *InMemoryUserStore) Update(user *User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.users[user.Username] == nil {
+		return ErrNotFound
+	}
+
+	store.users[user.Username] = user.Clone()
+	return nil
+}
+
+func (store *InMemoryUserStore) Delete(username string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.users[username] == nil {
+		return ErrNotFound
+	}
+
+	delete(store.users, username)
+	return nil
+}
+
+func (store *InMemoryUserStore) List(after string, limit int) ([]*User, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	usernames := make([]string, 0, len(store.users))
+	for username := range store.users {
+		if username > after {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+
+	if limit > 0 && len(usernames) > limit {
+		usernames = usernames[:limit]
+	}
+
+	users := make([]*User, 0, len(usernames))
+	for _, username := range usernames {
+		users = append(users, store.users[username].Clone())
+	}
+	return users, nil
+}
+
 func NewInMemoryUserStore() *InMemoryUserStore {
 	return &InMemoryUserStore{
 		users: make(map[string]*User),
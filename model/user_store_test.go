@@ -0,0 +1,13 @@
+package model_test
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	"github.com/simp7/pracgrpc/model/storetest"
+	"testing"
+)
+
+func TestInMemoryUserStore(t *testing.T) {
+	storetest.TestUserStore(t, func(t *testing.T) model.UserStore {
+		return model.NewInMemoryUserStore()
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+)
+
+type AdminServer struct {
+	certReloader *CertReloader
+	loginLimiter *LoginLimiter
+	pb.UnimplementedAdminServer
+}
+
+func NewAdminServer(certReloader *CertReloader, loginLimiter *LoginLimiter) *AdminServer {
+	return &AdminServer{certReloader, loginLimiter, pb.UnimplementedAdminServer{}}
+}
+
+func (server *AdminServer) ReloadCertificates(ctx context.Context, req *pb.ReloadCertificatesRequest) (*pb.ReloadCertificatesResponse, error) {
+	if server.certReloader == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "TLS is not enabled")
+	}
+
+	if err := server.certReloader.Reload(); err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot reload certificates: %v", err)
+	}
+
+	cert := server.certReloader.Certificate()
+	res := &pb.ReloadCertificatesResponse{
+		Subject:      cert.Subject.String(),
+		SerialNumber: cert.SerialNumber.String(),
+		NotAfter:     timestamppb.New(cert.NotAfter),
+	}
+	return res, nil
+}
+
+func (server *AdminServer) UnlockAccount(ctx context.Context, req *pb.UnlockAccountRequest) (*pb.UnlockAccountResponse, error) {
+	if req.GetUsername() == "" && req.GetAddress() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "username or address is required")
+	}
+
+	cleared := server.loginLimiter.Unlock(req.GetUsername(), req.GetAddress())
+	if cleared {
+		log.Printf("login lockout cleared for user %q and address %q", req.GetUsername(), req.GetAddress())
+	}
+	return &pb.UnlockAccountResponse{Cleared: cleared}, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const apiKeyPrefix = "pk"
+
+// apiKeyUsernamePrefix starts the username of every API key principal, which
+// is made of the key ID since names are neither unique nor stable.
+const apiKeyUsernamePrefix = "apikey:"
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyInvalid  = errors.New("api key is invalid")
+)
+
+// APIKey is a credential for a service account. Its ID is the public part of
+// the key, only the hash of the whole key is stored. A key bound to a role
+// follows the scopes of that role, narrowed to Scopes when those are set.
+type APIKey struct {
+	ID         string    `json:"id"`
+	Hash       string    `json:"hash"`
+	Name       string    `json:"name"`
+	Role       string    `json:"role,omitempty"`
+	Scopes     []string  `json:"scopes,omitempty"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type APIKeyStore interface {
+	Save(key *APIKey) error
+	Find(id string) (*APIKey, error)
+	List() ([]*APIKey, error)
+	Delete(id string) error
+	Touch(id string, usedAt time.Time) error
+}
+
+type InMemoryAPIKeyStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*APIKey
+}
+
+type APIKeyManager struct {
+	store  APIKeyStore
+	policy AccessPolicy
+}
+
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		keys: make(map[string]*APIKey),
+	}
+}
+
+func (store *InMemoryAPIKeyStore) Save(key *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	copied := *key
+	store.keys[key.ID] = &copied
+	return nil
+}
+
+func (store *InMemoryAPIKeyStore) Find(id string) (*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	key := store.keys[id]
+	if key == nil {
+		return nil, nil
+	}
+
+	copied := *key
+	return &copied, nil
+}
+
+func (store *InMemoryAPIKeyStore) List() ([]*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	keys := make([]*APIKey, 0, len(store.keys))
+	for _, key := range store.keys {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (store *InMemoryAPIKeyStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.keys[id]; !ok {
+		return ErrAPIKeyNotFound
+	}
+	delete(store.keys, id)
+	return nil
+}
+
+func (store *InMemoryAPIKeyStore) Touch(id string, usedAt time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := store.keys[id]
+	if key == nil {
+		return ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = usedAt
+	return nil
+}
+
+func NewAPIKeyManager(store APIKeyStore, policy AccessPolicy) *APIKeyManager {
+	return &APIKeyManager{store, policy}
+}
+
+// Create returns a new key and its secret, which is shown only once. The key
+// may not carry scopes its creator does not hold.
+func (manager *APIKeyManager) Create(name string, role string, scopes []string, creator *Principal) (*APIKey, string, error) {
+	granted := scopes
+	if role != "" {
+		var err error
+		granted, err = grantScopes(manager.policy, role, scopes)
+		if err != nil {
+			return nil, "", err
+		}
+	} else if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("api key needs a role or scopes")
+	}
+
+	for _, scope := range granted {
+		if !creator.HasScope(scope) {
+			return nil, "", fmt.Errorf("cannot grant scope %s that the creator does not hold", scope)
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	rawKey := apiKeyPrefix + "_" + id + "_" + secret
+
+	key := &APIKey{
+		ID:        id,
+		Hash:      hashAPIKey(rawKey),
+		Name:      name,
+		Role:      role,
+		Scopes:    scopes,
+		CreatedBy: creator.Username,
+		CreatedAt: time.Now(),
+	}
+	if err := manager.store.Save(key); err != nil {
+		return nil, "", fmt.Errorf("cannot save api key: %w", err)
+	}
+	return key, rawKey, nil
+}
+
+func (manager *APIKeyManager) List() ([]*APIKey, error) {
+	return manager.store.List()
+}
+
+func (manager *APIKeyManager) Revoke(id string) error {
+	return manager.store.Delete(id)
+}
+
+// Authenticate maps rawKey to the principal of its service account and
+// records the use.
+func (manager *APIKeyManager) Authenticate(rawKey string) (*Principal, error) {
+	prefix, rest, ok := strings.Cut(rawKey, "_")
+	if !ok || prefix != apiKeyPrefix {
+		return nil, ErrAPIKeyInvalid
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	key, err := manager.store.Find(id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find api key: %w", err)
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKey(rawKey))) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	scopes := key.Scopes
+	if key.Role != "" {
+		scopes, err = grantScopes(manager.policy, key.Role, key.Scopes)
+		if err != nil {
+			return nil, fmt.Errorf("api key scopes are no longer available: %w", err)
+		}
+	}
+
+	if err := manager.store.Touch(key.ID, time.Now()); err != nil {
+		return nil, fmt.Errorf("cannot record api key use: %w", err)
+	}
+
+	return &Principal{
+		Username:   apiKeyUsernamePrefix + key.ID,
+		Role:       key.Role,
+		Scopes:     scopes,
+		TokenID:    key.ID,
+		AuthMethod: AuthMethodAPIKey,
+		Machine:    true,
+	}, nil
+}
+
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("cannot generate random bytes: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
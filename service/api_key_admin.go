@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+)
+
+type APIKeyAdminServer struct {
+	apiKeys *APIKeyManager
+	pb.UnimplementedAPIKeyAdminServer
+}
+
+func NewAPIKeyAdminServer(apiKeys *APIKeyManager) *APIKeyAdminServer {
+	return &APIKeyAdminServer{apiKeys, pb.UnimplementedAPIKeyAdminServer{}}
+}
+
+func (server *APIKeyAdminServer) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "caller is not authenticated")
+	}
+	if req.GetName() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "name is required")
+	}
+
+	key, secret, err := server.apiKeys.Create(req.GetName(), req.GetRole(), req.GetScopes(), principal)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot create api key: %v", err)
+	}
+
+	log.Printf("api key %s created for %s by %s", key.ID, key.Name, principal.Username)
+	return &pb.CreateAPIKeyResponse{Key: apiKeyInfo(key), Secret: secret}, nil
+}
+
+func (server *APIKeyAdminServer) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	keys, err := server.apiKeys.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list api keys: %v", err)
+	}
+
+	res := &pb.ListAPIKeysResponse{}
+	for _, key := range keys {
+		res.Keys = append(res.Keys, apiKeyInfo(key))
+	}
+	return res, nil
+}
+
+func (server *APIKeyAdminServer) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*emptypb.Empty, error) {
+	err := server.apiKeys.Revoke(req.GetId())
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return nil, status.Errorf(codes.NotFound, "api key does not exist")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke api key: %v", err)
+	}
+
+	log.Printf("api key revoked: %s", req.GetId())
+	return &emptypb.Empty{}, nil
+}
+
+func apiKeyInfo(key *APIKey) *pb.APIKey {
+	info := &pb.APIKey{
+		Id:        key.ID,
+		Name:      key.Name,
+		Role:      key.Role,
+		Scopes:    key.Scopes,
+		CreatedBy: key.CreatedBy,
+		CreatedAt: timestamppb.New(key.CreatedAt),
+	}
+	if !key.LastUsedAt.IsZero() {
+		info.LastUsedAt = timestamppb.New(key.LastUsedAt)
+	}
+	return info
+}
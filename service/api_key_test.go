@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestAPIKeyManager(t *testing.T) *APIKeyManager {
+	t.Helper()
+
+	return NewAPIKeyManager(NewInMemoryAPIKeyStore(), newTestPolicyStore(t, testRoles))
+}
+
+func adminPrincipal() *Principal {
+	return &Principal{Username: "admin", Role: "admin", Scopes: []string{"password:change", "product:read", "product:write"}}
+}
+
+func TestAPIKeyManagerCreate(t *testing.T) {
+	manager := newTestAPIKeyManager(t)
+	reader := &Principal{Username: "alice", Role: "user", Scopes: []string{"product:read"}}
+
+	tests := []struct {
+		name    string
+		role    string
+		scopes  []string
+		creator *Principal
+		wantErr bool
+	}{
+		{"role", "user", nil, adminPrincipal(), false},
+		{"narrowed role", "admin", []string{"product:read"}, adminPrincipal(), false},
+		{"scopes only", "", []string{"product:read"}, reader, false},
+		{"neither role nor scopes", "", nil, adminPrincipal(), true},
+		{"undefined role", "guest", nil, adminPrincipal(), true},
+		{"scope outside the role", "user", []string{"product:write"}, adminPrincipal(), true},
+		{"scope the creator lacks", "", []string{"product:write"}, reader, true},
+		{"role the creator lacks", "admin", nil, reader, true},
+	}
+	for _, test := range tests {
+		_, _, err := manager.Create("billing", test.role, test.scopes, test.creator)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: Create() error = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestAPIKeyManagerAuthenticate(t *testing.T) {
+	manager := newTestAPIKeyManager(t)
+
+	key, rawKey, err := manager.Create("billing", "admin", []string{"product:read"}, adminPrincipal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(key.Hash, rawKey) || !strings.HasPrefix(rawKey, apiKeyPrefix+"_"+key.ID+"_") {
+		t.Fatalf("Create() = %q with hash %q, want a prefixed key stored as a hash", rawKey, key.Hash)
+	}
+
+	principal, err := manager.Authenticate(rawKey)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if principal.Username != apiKeyUsernamePrefix+key.ID || principal.AuthMethod != AuthMethodAPIKey {
+		t.Fatalf("principal = %+v, want the key id authenticated by api key", principal)
+	}
+	if !reflect.DeepEqual(principal.Scopes, []string{"product:read"}) {
+		t.Fatalf("Scopes = %v, want the narrowed scopes", principal.Scopes)
+	}
+
+	stored, err := manager.store.Find(key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.LastUsedAt.IsZero() {
+		t.Fatal("expected Authenticate() to record the use of the key")
+	}
+
+	for _, invalid := range []string{"", "pk", "pk_" + key.ID, rawKey + "0", "xx" + rawKey[2:], "pk_unknown_secret"} {
+		if _, err := manager.Authenticate(invalid); err != ErrAPIKeyInvalid {
+			t.Errorf("Authenticate(%q) error = %v, want %v", invalid, err, ErrAPIKeyInvalid)
+		}
+	}
+
+	if err := manager.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Authenticate(rawKey); err != ErrAPIKeyInvalid {
+		t.Fatalf("Authenticate() of a revoked key error = %v, want %v", err, ErrAPIKeyInvalid)
+	}
+	if err := manager.Revoke(key.ID); err != ErrAPIKeyNotFound {
+		t.Fatalf("Revoke() of a revoked key error = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+}
+
+func TestAPIKeyNamesDoNotIdentifyPrincipals(t *testing.T) {
+	manager := newTestAPIKeyManager(t)
+
+	first, firstKey, err := manager.Create("billing", "user", nil, adminPrincipal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, secondKey, err := manager.Create("billing", "user", nil, adminPrincipal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstPrincipal, err := manager.Authenticate(firstKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondPrincipal, err := manager.Authenticate(secondKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == second.ID || firstPrincipal.Username == secondPrincipal.Username {
+		t.Fatalf("keys of the same name share the principal %s", firstPrincipal.Username)
+	}
+}
+
+func TestAuthInterceptorAPIKey(t *testing.T) {
+	interceptor, _, _ := newTestInterceptor(t, false)
+	manager := NewAPIKeyManager(NewInMemoryAPIKeyStore(), interceptor.policy)
+	WithAPIKeys(manager)(interceptor)
+
+	_, writer, err := manager.Create("importer", "admin", []string{"product:write"}, adminPrincipal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, reader, err := manager.Create("reporter", "user", nil, adminPrincipal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		code codes.Code
+	}{
+		{"granted scope", writer, codes.OK},
+		{"missing scope", reader, codes.PermissionDenied},
+		{"invalid key", writer + "0", codes.Unauthenticated},
+	}
+	for _, test := range tests {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", test.key))
+		if err := callUnary(interceptor, ctx, testMethod); status.Code(err) != test.code {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.code)
+		}
+	}
+}
+
+func TestCreateAPIKeyReturnsSecretOnce(t *testing.T) {
+	server := NewAPIKeyAdminServer(newTestAPIKeyManager(t))
+	ctx := ContextWithPrincipal(context.Background(), adminPrincipal())
+
+	created, err := server.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{Name: "billing", Role: "user"})
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	if created.GetSecret() == "" {
+		t.Fatal("expected CreateAPIKey() to return the secret")
+	}
+
+	listed, err := server.ListAPIKeys(ctx, &pb.ListAPIKeysRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.GetKeys()) != 1 || listed.GetKeys()[0].GetId() != created.GetKey().GetId() {
+		t.Fatalf("ListAPIKeys() = %v, want the created key", listed.GetKeys())
+	}
+
+	if _, err := server.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{Role: "user"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CreateAPIKey() without name error = %v, want %v", err, codes.InvalidArgument)
+	}
+	if _, err := server.CreateAPIKey(context.Background(), &pb.CreateAPIKeyRequest{Name: "billing", Role: "user"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("CreateAPIKey() without principal error = %v, want %v", err, codes.Unauthenticated)
+	}
+	if _, err := server.RevokeAPIKey(ctx, &pb.RevokeAPIKeyRequest{Id: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("RevokeAPIKey() of an unknown key error = %v, want %v", err, codes.NotFound)
+	}
+}
@@ -2,31 +2,74 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"log"
+	"strings"
 )
 
 type AuthInterceptor struct {
-	JWTManager      *JWTManager
-	accessibleRoles map[string][]string
+	JWTManager   *JWTManager
+	policy       AccessPolicy
+	passwords    *PasswordVerifier
+	apiKeys      *APIKeyManager
+	sessions     *SessionManager
+	certIdentity bool
 }
 
-func NewAuthInterceptor(JWTManager *JWTManager, accessibleRoles map[string][]string) *AuthInterceptor {
-	return &AuthInterceptor{JWTManager, accessibleRoles}
+type InterceptorOption func(interceptor *AuthInterceptor)
+
+func WithBasicAuth(passwords *PasswordVerifier) InterceptorOption {
+	return func(interceptor *AuthInterceptor) {
+		interceptor.passwords = passwords
+	}
+}
+
+func WithAPIKeys(apiKeys *APIKeyManager) InterceptorOption {
+	return func(interceptor *AuthInterceptor) {
+		interceptor.apiKeys = apiKeys
+	}
+}
+
+// WithSessions rejects tokens whose session was revoked or has expired.
+func WithSessions(sessions *SessionManager) InterceptorOption {
+	return func(interceptor *AuthInterceptor) {
+		interceptor.sessions = sessions
+	}
+}
+
+func WithCertificateIdentity() InterceptorOption {
+	return func(interceptor *AuthInterceptor) {
+		interceptor.certIdentity = true
+	}
+}
+
+func NewAuthInterceptor(JWTManager *JWTManager, policy AccessPolicy, opts ...InterceptorOption) *AuthInterceptor {
+	interceptor := &AuthInterceptor{JWTManager: JWTManager, policy: policy}
+	for _, opt := range opts {
+		opt(interceptor)
+	}
+	return interceptor
 }
 
 func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		log.Println("--> unary interceptor: ", info.FullMethod)
 
-		err := interceptor.authorize(ctx, info.FullMethod)
+		principal, err := interceptor.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
 
+		if principal != nil {
+			ctx = ContextWithPrincipal(ctx, principal)
+		}
 		return handler(ctx, req)
 	}
 }
@@ -35,41 +78,198 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		log.Println("--> stream interceptor: ", info.FullMethod)
 
-		err := interceptor.authorize(stream.Context(), info.FullMethod)
+		principal, err := interceptor.authorize(stream.Context(), info.FullMethod)
 		if err != nil {
 			return err
 		}
+
+		if principal != nil {
+			stream = &principalStream{stream, ContextWithPrincipal(stream.Context(), principal)}
+		}
 		return handler(srv, stream)
 	}
 }
 
-func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) error {
-	accessible, ok := interceptor.accessibleRoles[method]
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (*Principal, error) {
+	policy := interceptor.policy.Snapshot()
+	if policy.IsPublic(method) {
+		return nil, nil
+	}
+
+	required, ok := policy.Scopes(method)
+	if !ok && policy.DenyByDefault() {
+		return nil, status.Error(codes.PermissionDenied, "method is not covered by the authorization policy")
+	}
 	if !ok {
+		return nil, nil
+	}
+
+	principal, err := interceptor.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if principal.AuthMethod == AuthMethodBasic || principal.AuthMethod == AuthMethodCertificate {
+		principal.Scopes, _ = policy.RoleScopes(principal.Role)
+	}
+
+	if principal.Actor != "" {
+		log.Printf("impersonation: %s acting as %s called %s", principal.Actor, principal.Username, method)
+		if policy.BlocksImpersonation(method) {
+			return nil, status.Error(codes.PermissionDenied, "method cannot be called while impersonating")
+		}
+	}
+
+	if len(required) == 0 {
+		return principal, nil
+	}
+	for _, scope := range required {
+		if principal.HasScope(scope) {
+			return principal, nil
+		}
+	}
+
+	return nil, status.Error(codes.PermissionDenied, "no permission to access this RPC")
+}
+
+func (interceptor *AuthInterceptor) authenticate(ctx context.Context) (*Principal, error) {
+	if rawKey, ok := apiKeyFromContext(ctx); ok && interceptor.apiKeys != nil {
+		return interceptor.authenticateAPIKey(rawKey)
+	}
+
+	scheme, credentials, err := authorizationFromContext(ctx)
+	if err != nil {
+		if principal := interceptor.certificatePrincipal(ctx); principal != nil {
+			return principal, nil
+		}
+		return nil, err
+	}
+
+	switch scheme {
+	case "", "bearer":
+		claims, err := interceptor.JWTManager.Verify(credentials)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
+		}
+		if err := interceptor.verifySession(claims.SessionID); err != nil {
+			return nil, err
+		}
+		return principalFromClaims(claims), nil
+	case "basic":
+		if interceptor.passwords == nil {
+			return nil, status.Error(codes.Unauthenticated, "basic authentication is disabled")
+		}
+		return interceptor.authenticateBasic(ctx, credentials)
+	default:
+		return nil, status.Errorf(codes.Unauthenticated, "unsupported authorization scheme: %s", scheme)
+	}
+}
+
+func (interceptor *AuthInterceptor) authenticateBasic(ctx context.Context, credentials string) (*Principal, error) {
+	decoded, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "basic credentials are malformed")
+	}
+
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "basic credentials are malformed")
+	}
+
+	user, err := interceptor.passwords.Verify(ctx, username, password)
+	if errors.Is(err, errIncorrectCredentials) {
+		return nil, status.Error(codes.Unauthenticated, "incorrect username/password")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, status.Error(codes.Unauthenticated, "second factor is required, log in for a token instead")
+	}
+
+	return &Principal{Username: user.Username, Role: user.Role, AuthMethod: AuthMethodBasic}, nil
+}
+
+// verifySession rejects tokens of revoked sessions. Tokens of machine
+// principals belong to no session.
+func (interceptor *AuthInterceptor) verifySession(sessionID string) error {
+	if sessionID == "" || interceptor.sessions == nil {
 		return nil
 	}
 
+	err := interceptor.sessions.Verify(sessionID)
+	if errors.Is(err, ErrSessionRevoked) {
+		return status.Error(codes.Unauthenticated, "session has been revoked")
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot check session: %v", err)
+	}
+	return nil
+}
+
+func (interceptor *AuthInterceptor) authenticateAPIKey(rawKey string) (*Principal, error) {
+	principal, err := interceptor.apiKeys.Authenticate(rawKey)
+	if errors.Is(err, ErrAPIKeyInvalid) {
+		return nil, status.Error(codes.Unauthenticated, "api key is invalid")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot check api key: %v", err)
+	}
+	return principal, nil
+}
+
+func (interceptor *AuthInterceptor) certificatePrincipal(ctx context.Context) *Principal {
+	if !interceptor.certIdentity {
+		return nil
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	principal, err := certificateIdentity(tlsInfo.State.VerifiedChains[0][0])
+	if err != nil {
+		log.Printf("cannot map client certificate to user: %v", err)
+		return nil
+	}
+	return principal
+}
+
+// authorizationFromContext splits the authorization header into a lower-cased
+// scheme and its credentials. A bare token without a scheme is still accepted
+// for clients that predate the Bearer prefix.
+func authorizationFromContext(ctx context.Context) (string, string, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return status.Errorf(codes.Unauthenticated, "metadata is not provided")
+		return "", "", status.Errorf(codes.Unauthenticated, "metadata is not provided")
 	}
 
 	values := md["authorization"]
 	if len(values) == 0 {
-		return status.Error(codes.Unauthenticated, "authorization token is not provided")
+		return "", "", status.Error(codes.Unauthenticated, "authorization token is not provided")
 	}
 
-	accessToken := values[0]
-	claims, err := interceptor.JWTManager.Verify(accessToken)
-	if err != nil {
-		return status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
+	scheme, credentials, ok := strings.Cut(strings.TrimSpace(values[0]), " ")
+	if !ok {
+		return "", scheme, nil
 	}
+	return strings.ToLower(scheme), strings.TrimSpace(credentials), nil
+}
 
-	for _, role := range accessible {
-		if role == claims.Role {
-			return nil
-		}
+func apiKeyFromContext(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
 	}
 
-	return status.Error(codes.PermissionDenied, "no permission to access this RPC")
+	values := md["x-api-key"]
+	if len(values) == 0 {
+		return "", false
+	}
+	return strings.TrimSpace(values[0]), true
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/simp7/pracgrpc/model"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+const testMethod = "/ecommerce.ProductInfo/addProduct"
+
+func newTestInterceptor(t *testing.T, basicAuth bool) (*AuthInterceptor, *JWTManager, model.UserStore) {
+	t.Helper()
+
+	userStore := model.NewInMemoryUserStore()
+	user, err := model.NewUser("alice", "secret", "admin", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	jwtManager := newTestJWTManager(t, systemClock{})
+	policy := newTestPolicyStore(t, testRoles+"rules:\n  "+testMethod+": [product:write]\n")
+
+	var opts []InterceptorOption
+	if basicAuth {
+		opts = append(opts, WithBasicAuth(newTestPasswordVerifier(t, userStore)))
+	}
+	return NewAuthInterceptor(jwtManager, policy, opts...), jwtManager, userStore
+}
+
+func callUnary(interceptor *AuthInterceptor, ctx context.Context, method string) error {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	_, err := interceptor.Unary()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	return err
+}
+
+func withAuthorization(value string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", value))
+}
+
+func basicCredentials(username string, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func TestAuthInterceptorAuthorizationSchemes(t *testing.T) {
+	interceptor, jwtManager, userStore := newTestInterceptor(t, true)
+
+	user, err := userStore.Find("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwtManager.Generate(user, []string{"product:write"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		code          codes.Code
+	}{
+		{"bare token", token, codes.OK},
+		{"bearer token", "Bearer " + token, codes.OK},
+		{"lower-case scheme", "bearer " + token, codes.OK},
+		{"basic credentials", basicCredentials("alice", "secret"), codes.OK},
+		{"wrong password", basicCredentials("alice", "wrong"), codes.Unauthenticated},
+		{"unknown user", basicCredentials("bob", "secret"), codes.Unauthenticated},
+		{"malformed basic", "Basic not-base64!", codes.Unauthenticated},
+		{"basic without colon", "Basic " + base64.StdEncoding.EncodeToString([]byte("alice")), codes.Unauthenticated},
+		{"unsupported scheme", "Digest " + token, codes.Unauthenticated},
+		{"invalid token", "Bearer invalid", codes.Unauthenticated},
+	}
+	for _, test := range tests {
+		err := callUnary(interceptor, withAuthorization(test.authorization), testMethod)
+		if status.Code(err) != test.code {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.code)
+		}
+	}
+}
+
+func TestAuthInterceptorBasicAuthIsOptIn(t *testing.T) {
+	interceptor, _, _ := newTestInterceptor(t, false)
+
+	err := callUnary(interceptor, withAuthorization(basicCredentials("alice", "secret")), testMethod)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("error = %v, want %v", err, codes.Unauthenticated)
+	}
+}
+
+func TestAuthInterceptorDenyByDefault(t *testing.T) {
+	policy := newTestPolicyStore(t, testRoles+`
+default: deny
+public: [/ecommerce.AuthService/Login]
+rules:
+  `+testMethod+`: [product:write]
+`)
+	jwtManager := newTestJWTManager(t, systemClock{})
+	interceptor := NewAuthInterceptor(jwtManager, policy)
+
+	token, err := jwtManager.Generate(&model.User{Username: "alice", Role: "admin"}, []string{"product:write"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		{"public without credentials", context.Background(), "/ecommerce.AuthService/Login", codes.OK},
+		{"covered without credentials", context.Background(), testMethod, codes.Unauthenticated},
+		{"covered with credentials", withAuthorization("Bearer " + token), testMethod, codes.OK},
+		{"uncovered with credentials", withAuthorization("Bearer " + token), "/ecommerce.ProductInfo/getProduct", codes.PermissionDenied},
+		{"uncovered without credentials", context.Background(), "/ecommerce.ProductInfo/getProduct", codes.PermissionDenied},
+	}
+	for _, test := range tests {
+		if err := callUnary(interceptor, test.ctx, test.method); status.Code(err) != test.code {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.code)
+		}
+	}
+}
+
+func TestAuthInterceptorScopes(t *testing.T) {
+	policy := newTestPolicyStore(t, testRoles+`
+rules:
+  `+testMethod+`: [product:write]
+  /ecommerce.ProductInfo/getProduct: []
+`)
+	jwtManager := newTestJWTManager(t, systemClock{})
+	interceptor := NewAuthInterceptor(jwtManager, policy)
+
+	admin := &model.User{Username: "alice", Role: "admin"}
+	narrowed, err := jwtManager.Generate(admin, []string{"product:read"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unscoped, err := jwtManager.Generate(admin, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		{"missing scope", withAuthorization("Bearer " + narrowed), testMethod, codes.PermissionDenied},
+		{"no scopes", withAuthorization("Bearer " + unscoped), testMethod, codes.PermissionDenied},
+		{"empty rule", withAuthorization("Bearer " + unscoped), "/ecommerce.ProductInfo/getProduct", codes.OK},
+		{"empty rule without credentials", context.Background(), "/ecommerce.ProductInfo/getProduct", codes.Unauthenticated},
+	}
+	for _, test := range tests {
+		if err := callUnary(interceptor, test.ctx, test.method); status.Code(err) != test.code {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.code)
+		}
+	}
+}
+
+func TestAuthInterceptorBlocksImpersonation(t *testing.T) {
+	const otherMethod = "/ecommerce.ProductInfo/getProduct"
+	policy := newTestPolicyStore(t, testRoles+
+		"rules:\n  "+testMethod+": [product:write]\n  "+otherMethod+": [product:write]\n"+
+		"impersonation_blocked: ["+testMethod+"]\n")
+	jwtManager := newTestJWTManager(t, systemClock{})
+	interceptor := NewAuthInterceptor(jwtManager, policy)
+
+	user := &model.User{Username: "alice", Role: "admin"}
+	impersonation, err := jwtManager.GenerateImpersonation(user, []string{"product:write"}, "root", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, err := jwtManager.Generate(user, []string{"product:write"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := callUnary(interceptor, withAuthorization("Bearer "+impersonation), testMethod); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("blocked method while impersonating error = %v, want %v", err, codes.PermissionDenied)
+	}
+	if err := callUnary(interceptor, withAuthorization("Bearer "+impersonation), otherMethod); err != nil {
+		t.Fatalf("allowed method while impersonating error = %v", err)
+	}
+	if err := callUnary(interceptor, withAuthorization("Bearer "+own), testMethod); err != nil {
+		t.Fatalf("blocked method without impersonation error = %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	certReloadsSuccess = "success"
+	certReloadsFailure = "failure"
+)
+
+var (
+	certReloads    = expvar.NewMap("cert_reloads")
+	certReloadedAt = expvar.NewInt("cert_reloaded_at")
+	certNotAfter   = expvar.NewInt("cert_not_after")
+)
+
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	mutex    sync.Mutex
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Reload reads the key pair from disk. Only new handshakes pick up the
+// result; connections that are already open keep their certificate.
+func (reloader *CertReloader) Reload() error {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if err != nil {
+		certReloads.Add(certReloadsFailure, 1)
+		log.Printf("cannot reload certificate %s: %v", reloader.certFile, err)
+		return fmt.Errorf("cannot load server certificate: %w", err)
+	}
+
+	reloader.cert.Store(&cert)
+	certReloads.Add(certReloadsSuccess, 1)
+	certReloadedAt.Set(time.Now().Unix())
+	certNotAfter.Set(cert.Leaf.NotAfter.Unix())
+	log.Printf("certificate loaded: %s (serial %s, expires %s)", cert.Leaf.Subject, cert.Leaf.SerialNumber, cert.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func (reloader *CertReloader) Certificate() *x509.Certificate {
+	return reloader.cert.Load().Leaf
+}
+
+func (reloader *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return reloader.cert.Load(), nil
+}
+
+func (reloader *CertReloader) Watch(interval time.Duration, done <-chan struct{}) {
+	watchFiles([]string{reloader.certFile, reloader.keyFile}, interval, done, func() {
+		reloader.Reload()
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	first, certFile, keyFile := writeTestCertificate(t, dir, pkix.Name{CommonName: "server"})
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloader.Certificate().Equal(first) {
+		t.Fatal("expected the initial certificate to be served")
+	}
+
+	second, _, _ := writeTestCertificate(t, dir, pkix.Name{CommonName: "server"})
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	served, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !served.Leaf.Equal(second) {
+		t.Fatal("expected new handshakes to get the reloaded certificate")
+	}
+
+	if err := os.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err == nil {
+		t.Fatal("expected a broken key to fail the reload")
+	}
+	if !reloader.Certificate().Equal(second) {
+		t.Fatal("expected a failed reload to keep the previous certificate")
+	}
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeTestCertificate(t, dir, pkix.Name{CommonName: "server"})
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go reloader.Watch(10*time.Millisecond, done)
+
+	next, _, _ := writeTestCertificate(t, dir, pkix.Name{CommonName: "server"})
+
+	// The watcher may take its first snapshot after the write, so keep
+	// touching the file until it notices a change.
+	deadline := time.Now().Add(5 * time.Second)
+	for touched := time.Now(); !reloader.Certificate().Equal(next); {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the watcher to reload the changed certificate")
+		}
+		touched = touched.Add(time.Second)
+		if err := os.Chtimes(certFile, touched, touched); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReloadCertificatesRPC(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := NewAdminServer(nil, nil).ReloadCertificates(ctx, &pb.ReloadCertificatesRequest{})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("ReloadCertificates() without TLS error = %v, want %v", err, codes.FailedPrecondition)
+	}
+
+	cert, certFile, keyFile := writeTestCertificate(t, t.TempDir(), pkix.Name{CommonName: "server"})
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewAdminServer(reloader, nil).ReloadCertificates(ctx, &pb.ReloadCertificatesRequest{})
+	if err != nil {
+		t.Fatalf("ReloadCertificates() error = %v", err)
+	}
+	if res.GetSerialNumber() != cert.SerialNumber.String() {
+		t.Fatalf("SerialNumber = %s, want %s", res.GetSerialNumber(), cert.SerialNumber)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileAPIKeyStore keeps API keys in memory and writes all of them to a JSON
+// file whenever a key is created or deleted. Uses are recorded in memory only,
+// so the last use of a key survives a restart only if a later write saved it.
+type FileAPIKeyStore struct {
+	mutex  sync.Mutex
+	path   string
+	memory *InMemoryAPIKeyStore
+}
+
+func NewFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	store := &FileAPIKeyStore{
+		path:   path,
+		memory: NewInMemoryAPIKeyStore(),
+	}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileAPIKeyStore) Save(key *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.memory.Save(key); err != nil {
+		return err
+	}
+	if err := store.write(); err != nil {
+		store.memory.Delete(key.ID)
+		return err
+	}
+	return nil
+}
+
+func (store *FileAPIKeyStore) Find(id string) (*APIKey, error) {
+	return store.memory.Find(id)
+}
+
+func (store *FileAPIKeyStore) List() ([]*APIKey, error) {
+	return store.memory.List()
+}
+
+func (store *FileAPIKeyStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key, _ := store.memory.Find(id)
+	if err := store.memory.Delete(id); err != nil {
+		return err
+	}
+	if err := store.write(); err != nil {
+		store.memory.Save(key)
+		return err
+	}
+	return nil
+}
+
+func (store *FileAPIKeyStore) Touch(id string, usedAt time.Time) error {
+	return store.memory.Touch(id, usedAt)
+}
+
+func (store *FileAPIKeyStore) load() error {
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read api key store: %w", err)
+	}
+
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("cannot parse api key store: %w", err)
+	}
+	for _, key := range keys {
+		store.memory.keys[key.ID] = key
+	}
+	return nil
+}
+
+// write replaces the file with the current keys, swapping it in atomically so
+// a crash never leaves half of it behind.
+func (store *FileAPIKeyStore) write() error {
+	keys, _ := store.memory.List()
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("cannot encode api keys: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(store.path), ".apikeys-*")
+	if err != nil {
+		return fmt.Errorf("cannot create api key store: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot protect api key store: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write api key store: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write api key store: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("cannot write api key store: %w", err)
+	}
+	return os.Rename(file.Name(), store.path)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileAPIKeyStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db.apikeys")
+
+	store, err := NewFileAPIKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	for _, id := range []string{"kept", "revoked"} {
+		key := &APIKey{ID: id, Hash: "hash-" + id, Name: id, Scopes: []string{"order:read"}, CreatedBy: "admin1", CreatedAt: createdAt}
+		if err := store.Save(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete("revoked"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileAPIKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := reopened.Find("kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil || key.Hash != "hash-kept" || !key.CreatedAt.Equal(createdAt) || len(key.Scopes) != 1 {
+		t.Fatalf("unexpected key after reopen: %+v", key)
+	}
+
+	revoked, err := reopened.Find("revoked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != nil {
+		t.Fatal("expected deleted key to stay deleted after reopen")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// watchFiles polls the modification time of paths and calls onChange once per
+// interval in which any of them changed. It returns when done is closed.
+func watchFiles(paths []string, interval time.Duration, done <-chan struct{}, onChange func()) {
+	last := modTimes(paths)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			current := modTimes(paths)
+			for i := range paths {
+				if !current[i].Equal(last[i]) {
+					onChange()
+					break
+				}
+			}
+			last = current
+		}
+	}
+}
+
+func modTimes(paths []string) []time.Time {
+	times := make([]time.Time, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
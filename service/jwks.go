@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"math/big"
+)
+
+func (key *SigningKey) JWK() *pb.JSONWebKey {
+	jwk := &pb.JSONWebKey{
+		Kid: key.ID,
+		Use: "sig",
+		Alg: key.Method.Alg(),
+	}
+
+	switch publicKey := key.PublicKey().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeBigInt(publicKey.N, 0)
+		jwk.E = encodeBigInt(big.NewInt(int64(publicKey.E)), 0)
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = publicKey.Curve.Params().Name
+		jwk.X = encodeBigInt(publicKey.X, size)
+		jwk.Y = encodeBigInt(publicKey.Y, size)
+	}
+
+	return jwk
+}
+
+func (ring *KeyRing) JWKS() []*pb.JSONWebKey {
+	keys := ring.VerificationKeys()
+	jwks := make([]*pb.JSONWebKey, 0, len(keys))
+	for _, key := range keys {
+		jwks = append(jwks, key.JWK())
+	}
+	return jwks
+}
+
+func encodeBigInt(value *big.Int, size int) string {
+	buf := value.Bytes()
+	if len(buf) < size {
+		padded := make([]byte, size)
+		copy(padded[size-len(buf):], buf)
+		buf = padded
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -2,57 +2,154 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
 	"github.com/simp7/pracgrpc/model"
 	pb "github.com/simp7/pracgrpc/model/ecommerce"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"log"
 	"time"
 )
 
 type JWTManager struct {
-	secretKey     string
+	keyRing       *KeyRing
+	revocations   RevocationStore
 	tokenDuration time.Duration
+	issuer        string
+	audience      string
+	clockSkew     time.Duration
+	clock         Clock
+}
+
+type JWTOption func(manager *JWTManager)
+
+func WithIssuer(issuer string) JWTOption {
+	return func(manager *JWTManager) {
+		manager.issuer = issuer
+	}
+}
+
+func WithAudience(audience string) JWTOption {
+	return func(manager *JWTManager) {
+		manager.audience = audience
+	}
+}
+
+// WithClockSkew tolerates clocks of other token verifiers being off by up
+// to skew when checking exp, nbf and iat.
+func WithClockSkew(skew time.Duration) JWTOption {
+	return func(manager *JWTManager) {
+		manager.clockSkew = skew
+	}
+}
+
+func WithClock(clock Clock) JWTOption {
+	return func(manager *JWTManager) {
+		manager.clock = clock
+	}
 }
 
 type UserClaims struct {
 	jwt.StandardClaims
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	Username  string   `json:"username"`
+	Role      string   `json:"role"`
+	Scopes    []string `json:"scopes"`
+	Machine   bool     `json:"machine,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	Actor     *Actor   `json:"act,omitempty"`
+}
+
+// Actor names who is acting on behalf of the subject of a token, following
+// the act claim of RFC 8693.
+type Actor struct {
+	Subject string `json:"sub"`
 }
 
 type AuthServer struct {
-	userStore  model.UserStore
-	jwtManager *JWTManager
+	userStore     model.UserStore
+	clientStore   model.ClientStore
+	passwords     *PasswordVerifier
+	jwtManager    *JWTManager
+	refreshTokens *RefreshTokenManager
+	sessions      *SessionManager
+	secondFactors *SecondFactorManager
+	policy        AccessPolicy
+	passwordCost  int
 	pb.UnimplementedAuthServiceServer
 }
 
-func NewJWTManager(secretKey string, tokenDuration time.Duration) *JWTManager {
-	return &JWTManager{secretKey, tokenDuration}
+func NewJWTManager(keyRing *KeyRing, revocations RevocationStore, tokenDuration time.Duration, opts ...JWTOption) *JWTManager {
+	manager := &JWTManager{keyRing: keyRing, revocations: revocations, tokenDuration: tokenDuration, clock: systemClock{}}
+	for _, opt := range opts {
+		opt(manager)
+	}
+	return manager
+}
+
+// Generate issues a token to user within the session sessionID.
+func (manager *JWTManager) Generate(user *model.User, scopes []string, sessionID string) (string, error) {
+	return manager.sign(UserClaims{Username: user.Username, Role: user.Role, Scopes: scopes, SessionID: sessionID}, manager.tokenDuration)
+}
+
+// GenerateForClient issues a token to a backend service, marked as a machine
+// principal and carrying no role.
+func (manager *JWTManager) GenerateForClient(client *model.Client, scopes []string) (string, error) {
+	return manager.sign(UserClaims{Username: client.ID, Scopes: scopes, Machine: true}, manager.tokenDuration)
+}
+
+// GenerateImpersonation issues a token letting actor act as user for
+// duration within the impersonation session sessionID.
+func (manager *JWTManager) GenerateImpersonation(user *model.User, scopes []string, actor string, sessionID string, duration time.Duration) (string, error) {
+	claims := UserClaims{Username: user.Username, Role: user.Role, Scopes: scopes, SessionID: sessionID, Actor: &Actor{Subject: actor}}
+	return manager.sign(claims, duration)
 }
 
-func (manager *JWTManager) Generate(user *model.User) (string, error) {
-	claims := UserClaims{
-		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(manager.tokenDuration).Unix()},
-		Username:       user.Username,
-		Role:           user.Role,
+func (manager *JWTManager) sign(claims UserClaims, duration time.Duration) (string, error) {
+	tokenID, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("cannot generate token id: %w", err)
+	}
+
+	now := manager.clock.Now()
+	claims.StandardClaims = jwt.StandardClaims{
+		Id:        tokenID.String(),
+		Subject:   claims.Username,
+		Issuer:    manager.issuer,
+		Audience:  manager.audience,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(duration).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(manager.secretKey))
+	key := manager.keyRing.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
 }
 
 func (manager *JWTManager) Verify(accessToken string) (*UserClaims, error) {
 	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
+		kid, ok := token.Header["kid"].(string)
 		if !ok {
+			return nil, fmt.Errorf("token key id is missing")
+		}
+
+		key := manager.keyRing.Find(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown token key id: %s", kid)
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("unexpected token signing method")
 		}
-		return []byte(manager.secretKey), nil
+		return key.PublicKey(), nil
 	}
-	token, err := jwt.ParseWithClaims(
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(
 		accessToken,
 		&UserClaims{},
 		keyFunc,
@@ -67,29 +164,471 @@ func (manager *JWTManager) Verify(accessToken string) (*UserClaims, error) {
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	if err := manager.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	revoked, err := manager.revocations.IsRevoked(claims.Id, claims.Username, time.Unix(claims.IssuedAt, 0))
+	if err != nil {
+		return nil, fmt.Errorf("cannot check token revocation: %w", err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+
+	// Revoking the tokens of an admin also ends their impersonations.
+	if claims.Actor != nil {
+		revoked, err := manager.revocations.IsRevoked("", claims.Actor.Subject, time.Unix(claims.IssuedAt, 0))
+		if err != nil {
+			return nil, fmt.Errorf("cannot check token revocation: %w", err)
+		}
+		if revoked {
+			return nil, fmt.Errorf("token actor has been revoked")
+		}
+	}
+
 	return claims, nil
 }
 
-func NewAuthServer(userStore model.UserStore, jwtManager *JWTManager) *AuthServer {
-	return &AuthServer{userStore, jwtManager, pb.UnimplementedAuthServiceServer{}}
+// validateClaims checks the registered claims strictly: each of them must be
+// present, since jwt-go treats missing time claims as valid.
+func (manager *JWTManager) validateClaims(claims *UserClaims) error {
+	now := manager.clock.Now()
+
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(manager.clockSkew)) {
+		return fmt.Errorf("token is expired")
+	}
+	if claims.NotBefore == 0 || now.Add(manager.clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if claims.IssuedAt == 0 || now.Add(manager.clockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return fmt.Errorf("token is issued in the future")
+	}
+	if claims.Issuer != manager.issuer {
+		return fmt.Errorf("unexpected token issuer: %s", claims.Issuer)
+	}
+	if claims.Audience != manager.audience {
+		return fmt.Errorf("unexpected token audience: %s", claims.Audience)
+	}
+	if claims.Subject == "" || claims.Subject != claims.Username {
+		return fmt.Errorf("token subject does not match its user")
+	}
+	if claims.Actor != nil && claims.Actor.Subject == "" {
+		return fmt.Errorf("token actor has no subject")
+	}
+	return nil
+}
+
+func (manager *JWTManager) Revoke(tokenID string, expiresAt time.Time) error {
+	return manager.revocations.Revoke(tokenID, expiresAt)
+}
+
+func (manager *JWTManager) RevokeUser(username string) error {
+	now := manager.clock.Now()
+	return manager.revocations.RevokeUser(username, now, now.Add(manager.tokenDuration))
+}
+
+func NewAuthServer(userStore model.UserStore, clientStore model.ClientStore, passwords *PasswordVerifier, jwtManager *JWTManager, refreshTokens *RefreshTokenManager, sessions *SessionManager, secondFactors *SecondFactorManager, policy AccessPolicy, passwordCost int) *AuthServer {
+	return &AuthServer{userStore, clientStore, passwords, jwtManager, refreshTokens, sessions, secondFactors, policy, passwordCost, pb.UnimplementedAuthServiceServer{}}
 }
 
 func (server *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
-	user, err := server.userStore.Find(req.GetUsername())
+	user, err := server.passwords.Verify(ctx, req.GetUsername(), req.GetPassword())
+	if errors.Is(err, errIncorrectCredentials) {
+		return nil, status.Errorf(codes.Unauthenticated, "incorrect username/password")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.NeedsRehash(server.passwordCost) {
+		server.rehashPassword(user, req.GetPassword())
+	}
+
+	if _, err := grantScopes(server.policy, user.Role, req.GetScopes()); err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "%v", err)
+	}
+
+	if user.TOTPEnabled {
+		challenge, err := server.secondFactors.Challenge(user, req.GetScopes())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot create second factor challenge")
+		}
+		return &pb.LoginResponse{SecondFactorRequired: true, Challenge: challenge}, nil
+	}
+
+	return server.issueTokens(ctx, user, req.GetScopes())
+}
+
+// VerifySecondFactor finishes a login that Login answered with a challenge.
+func (server *AuthServer) VerifySecondFactor(ctx context.Context, req *pb.VerifySecondFactorRequest) (*pb.LoginResponse, error) {
+	user, challenge, err := server.secondFactors.Verify(req.GetChallenge(), req.GetCode(), peerAddress(ctx))
+	if err != nil {
+		return nil, secondFactorError(err)
+	}
+	return server.issueTokens(ctx, user, challenge.Scopes)
+}
+
+// issueTokens starts a session of user for the client calling with ctx.
+func (server *AuthServer) issueTokens(ctx context.Context, user *model.User, requested []string) (*pb.LoginResponse, error) {
+	scopes, err := grantScopes(server.policy, user.Role, requested)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "%v", err)
+	}
+
+	session, refreshToken, err := server.sessions.Start(ctx, user.Username, requested)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot start session: %v", err)
+	}
+
+	token, err := server.jwtManager.Generate(user, scopes, session.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate access token")
+	}
+
+	res := &pb.LoginResponse{AccessToken: token, RefreshToken: refreshToken, Scopes: scopes}
+	return res, nil
+}
+
+// EnrollTOTP starts TOTP enrollment of the caller. The returned URI is meant
+// to be shown as a QR code.
+func (server *AuthServer) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	principal, err := secondFactorPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := server.secondFactors.Enroll(principal.Username)
+	if err != nil {
+		return nil, secondFactorError(err)
+	}
+
+	uri := model.TOTPURI(server.jwtManager.issuer, principal.Username, secret)
+	return &pb.EnrollTOTPResponse{Secret: secret, Uri: uri}, nil
+}
+
+func (server *AuthServer) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	principal, err := secondFactorPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := server.secondFactors.Confirm(principal.Username, req.GetCode(), peerAddress(ctx))
+	if err != nil {
+		return nil, secondFactorError(err)
+	}
+
+	log.Printf("totp enabled for user: %s", principal.Username)
+	return &pb.ConfirmTOTPResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (server *AuthServer) DisableTOTP(ctx context.Context, req *pb.DisableTOTPRequest) (*pb.DisableTOTPResponse, error) {
+	principal, err := secondFactorPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := server.secondFactors.Disable(principal.Username, req.GetCode(), peerAddress(ctx)); err != nil {
+		return nil, secondFactorError(err)
+	}
+
+	log.Printf("totp disabled for user: %s", principal.Username)
+	return &pb.DisableTOTPResponse{}, nil
+}
+
+// secondFactorPrincipal returns the caller if it is a user who logged in,
+// since machines have no second factor to manage.
+func secondFactorPrincipal(ctx context.Context) (*Principal, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "caller is not authenticated")
+	}
+	if principal.Machine || principal.AuthMethod != AuthMethodJWT {
+		return nil, status.Errorf(codes.FailedPrecondition, "second factor can only be managed with a user token")
+	}
+	return principal, nil
+}
+
+func secondFactorError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, ErrChallengeInvalid), errors.Is(err, ErrSecondFactorIncorrect), errors.Is(err, ErrSecondFactorUserAbsent):
+		return status.Errorf(codes.Unauthenticated, "%v", err)
+	case errors.Is(err, ErrTOTPAlreadyEnabled), errors.Is(err, ErrTOTPNotEnrolled), errors.Is(err, ErrTOTPNotEnabled):
+		return status.Errorf(codes.FailedPrecondition, "%v", err)
+	default:
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+}
+
+// rehashPassword upgrades the stored hash to the current cost. Failing to do
+// so does not fail the login, the next one tries again.
+func (server *AuthServer) rehashPassword(user *model.User, password string) {
+	if err := user.SetPassword(password, server.passwordCost); err != nil {
+		log.Printf("cannot rehash password of %s: %v", user.Username, err)
+		return
+	}
+	if err := server.userStore.Update(user); err != nil {
+		log.Printf("cannot save rehashed password of %s: %v", user.Username, err)
+		return
+	}
+	log.Printf("password of %s rehashed with cost %d", user.Username, server.passwordCost)
+}
+
+func (server *AuthServer) Refresh(ctx context.Context, req *pb.RefreshRequest) (*pb.RefreshResponse, error) {
+	previous, refreshToken, err := server.refreshTokens.Rotate(req.GetRefreshToken())
+	var reused *RefreshTokenReusedError
+	if errors.As(err, &reused) {
+		if err := server.sessions.Revoke(reused.FamilyID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			log.Printf("cannot revoke session %s after refresh token reuse: %v", reused.FamilyID, err)
+		}
+		log.Printf("refresh token reuse detected, session %s revoked", reused.FamilyID)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "refresh token is invalid: %v", err)
+	}
+
+	err = server.sessions.Extend(previous.FamilyID)
+	if errors.Is(err, ErrSessionRevoked) {
+		return nil, status.Errorf(codes.Unauthenticated, "session has been revoked")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot extend session: %v", err)
+	}
+
+	user, err := server.userStore.Find(previous.Username)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
 	}
-	log.Printf("Generate user: %s - %s", user.Username, user.HashedPassword)
+	if user == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "user no longer exists")
+	}
 
-	if user == nil || !user.IsCorrectPassword(req.GetPassword()) {
-		return nil, status.Errorf(codes.NotFound, "incorrect username/password")
+	scopes, err := grantScopes(server.policy, user.Role, previous.Scopes)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "granted scopes are no longer available: %v", err)
 	}
 
-	token, err := server.jwtManager.Generate(user)
+	token, err := server.jwtManager.Generate(user, scopes, previous.FamilyID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot generate access token")
 	}
 
-	res := &pb.LoginResponse{AccessToken: token}
+	res := &pb.RefreshResponse{AccessToken: token, RefreshToken: refreshToken}
+	return res, nil
+}
+
+func (server *AuthServer) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "caller is not authenticated")
+	}
+	if principal.AuthMethod != AuthMethodJWT {
+		return nil, status.Errorf(codes.FailedPrecondition, "only token sessions can be logged out")
+	}
+
+	if err := server.jwtManager.Revoke(principal.TokenID, principal.ExpiresAt); err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke access token: %v", err)
+	}
+
+	if principal.SessionID != "" {
+		err := server.sessions.Revoke(principal.SessionID)
+		if err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return nil, status.Errorf(codes.Internal, "cannot end session: %v", err)
+		}
+	}
+
+	if req.GetRefreshToken() != "" {
+		err := server.refreshTokens.Revoke(req.GetRefreshToken())
+		if err != nil && !errors.Is(err, ErrRefreshTokenNotFound) {
+			return nil, status.Errorf(codes.Internal, "cannot revoke refresh token: %v", err)
+		}
+	}
+
+	return &pb.LogoutResponse{}, nil
+}
+
+func (server *AuthServer) RevokeUserTokens(ctx context.Context, req *pb.RevokeUserTokensRequest) (*pb.RevokeUserTokensResponse, error) {
+	if req.GetUsername() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "username is required")
+	}
+
+	if err := revokeUserTokens(server.jwtManager, server.sessions, req.GetUsername()); err != nil {
+		return nil, err
+	}
+
+	log.Printf("revoked every token of user: %s", req.GetUsername())
+	return &pb.RevokeUserTokensResponse{}, nil
+}
+
+// Token implements the OAuth2 client-credentials grant for backend services.
+func (server *AuthServer) Token(ctx context.Context, req *pb.TokenRequest) (*pb.TokenResponse, error) {
+	if req.GetGrantType() != "client_credentials" {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported grant type: %s", req.GetGrantType())
+	}
+
+	client, err := server.clientStore.Find(req.GetClientId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find client: %v", err)
+	}
+	if client == nil || !client.IsCorrectSecret(req.GetClientSecret()) {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid client credentials")
+	}
+
+	scopes, err := narrowScopes(client.Scopes, req.GetScopes())
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "%v", err)
+	}
+
+	token, err := server.jwtManager.GenerateForClient(client, scopes)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate access token")
+	}
+
+	res := &pb.TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(server.jwtManager.tokenDuration.Seconds()),
+		Scopes:      scopes,
+	}
+	return res, nil
+}
+
+// Introspect reports whether token is currently accepted and what it grants.
+// Inactive tokens carry no claims, so callers cannot learn why they failed.
+func (server *AuthServer) Introspect(ctx context.Context, req *pb.IntrospectRequest) (*pb.IntrospectResponse, error) {
+	claims, err := server.jwtManager.Verify(req.GetToken())
+	if err == nil && claims.SessionID != "" {
+		err = server.checkSession(claims.SessionID)
+	}
+	if err != nil {
+		log.Printf("introspected token is inactive: %v", err)
+		return &pb.IntrospectResponse{Active: false}, nil
+	}
+
+	res := &pb.IntrospectResponse{
+		Active:   true,
+		Sub:      claims.Subject,
+		Username: claims.Username,
+		Role:     claims.Role,
+		Scopes:   claims.Scopes,
+		Machine:  claims.Machine,
+		Iss:      claims.Issuer,
+		Aud:      claims.Audience,
+		Jti:      claims.Id,
+		Iat:      claims.IssuedAt,
+		Nbf:      claims.NotBefore,
+		Exp:      claims.ExpiresAt,
+	}
+	if claims.Actor != nil {
+		res.Act = claims.Actor.Subject
+	}
+	return res, nil
+}
+
+// ListSessions returns the sessions of the caller. Listing those of another
+// user requires the user:admin scope. Impersonation sessions are listed with
+// the impersonated user and name the admin acting in them, so users can see
+// who acted as them.
+func (server *AuthServer) ListSessions(ctx context.Context, req *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "caller is not authenticated")
+	}
+
+	username := req.GetUsername()
+	if username == "" {
+		username = principal.Username
+	}
+	if username != principal.Username && !principal.HasScope(ScopeUserAdmin) {
+		return nil, status.Errorf(codes.PermissionDenied, "cannot list sessions of another user")
+	}
+
+	sessions, err := server.sessions.List(username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list sessions: %v", err)
+	}
+
+	res := &pb.ListSessionsResponse{}
+	for _, session := range sessions {
+		res.Sessions = append(res.Sessions, sessionInfo(session, principal))
+	}
+	return res, nil
+}
+
+// RevokeSession ends a session of the caller, or of anyone for holders of the
+// user:admin scope. An impersonation session can be ended by the impersonated
+// user as well as by the admin acting in it, but never with an impersonation
+// token, so an admin cannot end the sessions of a user while acting as them.
+// Sessions of other users look like missing ones.
+func (server *AuthServer) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "caller is not authenticated")
+	}
+	if principal.Actor != "" {
+		return nil, status.Errorf(codes.PermissionDenied, "sessions cannot be revoked while impersonating")
+	}
+
+	session, err := server.sessions.Find(req.GetSessionId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find session: %v", err)
+	}
+	if session == nil || (session.Username != principal.Username && session.Actor != principal.Username && !principal.HasScope(ScopeUserAdmin)) {
+		return nil, status.Errorf(codes.NotFound, "session does not exist")
+	}
+
+	err = server.sessions.Revoke(session.ID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, status.Errorf(codes.NotFound, "session does not exist")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke session: %v", err)
+	}
+
+	log.Printf("session %s of %s revoked by %s", session.ID, session.Username, principal.Username)
+	return &pb.RevokeSessionResponse{}, nil
+}
+
+func (server *AuthServer) checkSession(sessionID string) error {
+	session, err := server.sessions.Find(sessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+func sessionInfo(session *Session, principal *Principal) *pb.Session {
+	return &pb.Session{
+		Id:          session.ID,
+		Username:    session.Username,
+		UserAgent:   session.UserAgent,
+		PeerAddress: session.PeerAddress,
+		CreatedAt:   timestamppb.New(session.CreatedAt),
+		LastUsedAt:  timestamppb.New(session.LastUsedAt),
+		ExpiresAt:   timestamppb.New(session.ExpiresAt),
+		Current:     session.ID == principal.SessionID,
+		Actor:       session.Actor,
+	}
+}
+
+func revokeUserTokens(jwtManager *JWTManager, sessions *SessionManager, username string) error {
+	if err := jwtManager.RevokeUser(username); err != nil {
+		return status.Errorf(codes.Internal, "cannot revoke access tokens: %v", err)
+	}
+	if err := sessions.RevokeUser(username); err != nil {
+		return status.Errorf(codes.Internal, "cannot revoke sessions: %v", err)
+	}
+	return nil
+}
+
+func (server *AuthServer) GetPublicKeys(ctx context.Context, req *pb.GetPublicKeysRequest) (*pb.GetPublicKeysResponse, error) {
+	res := &pb.GetPublicKeysResponse{Keys: server.jwtManager.keyRing.JWKS()}
 	return res, nil
 }
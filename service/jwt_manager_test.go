@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/simp7/pracgrpc/model"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(d)
+}
+
+const (
+	testTokenDuration = 15 * time.Minute
+	testClockSkew     = 30 * time.Second
+)
+
+func newTestJWTManager(t *testing.T, clock Clock) *JWTManager {
+	t.Helper()
+
+	key, err := GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewJWTManager(
+		NewKeyRing(key, clock),
+		NewInMemoryRevocationStore(clock),
+		testTokenDuration,
+		WithIssuer("pracgrpc"),
+		WithAudience("pracgrpc"),
+		WithClockSkew(testClockSkew),
+		WithClock(clock),
+	)
+}
+
+// signTestToken signs claims with key but labels the token with kid, which
+// lets tests forge tokens the manager would never issue.
+func signTestToken(t *testing.T, key *SigningKey, kid string, claims UserClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// validClaims are the claims a manager without issuer or audience issues to
+// alice at now.
+func validClaims(now time.Time) UserClaims {
+	return UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "alice",
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		},
+		Username: "alice",
+		Role:     "user",
+	}
+}
+
+func newTestAuthServer(t *testing.T, clock Clock, userStore model.UserStore, limiter *LoginLimiter) *AuthServer {
+	t.Helper()
+
+	policy, err := NewPolicyStore("policy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords, err := NewPasswordVerifier(userStore, limiter, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshTokens := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(clock), time.Hour, clock)
+	return NewAuthServer(
+		userStore,
+		model.NewInMemoryClientStore(),
+		passwords,
+		newTestJWTManager(t, clock),
+		refreshTokens,
+		NewSessionManager(NewInMemorySessionStore(clock), refreshTokens, clock),
+		NewSecondFactorManager(userStore, limiter, 5*time.Minute, 5, clock),
+		policy,
+		bcrypt.MinCost,
+	)
+}
+
+// issueAt signs a token for alice while the clock reads issued, then moves
+// the clock by verifyAfter.
+func issueAt(t *testing.T, manager *JWTManager, clock *fakeClock, verifyAfter time.Duration) string {
+	t.Helper()
+
+	token, err := manager.Generate(&model.User{Username: "alice", Role: "user"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(verifyAfter)
+	return token
+}
+
+func TestJWTManagerTimeClaims(t *testing.T) {
+	tests := []struct {
+		name        string
+		verifyAfter time.Duration
+		valid       bool
+	}{
+		{"fresh", 0, true},
+		{"just before exp", testTokenDuration - time.Second, true},
+		{"expired within skew", testTokenDuration + testClockSkew - time.Second, true},
+		{"expired beyond skew", testTokenDuration + testClockSkew + time.Second, false},
+		{"issued ahead within skew", -testClockSkew + time.Second, true},
+		{"issued ahead beyond skew", -testClockSkew - time.Second, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock()
+			manager := newTestJWTManager(t, clock)
+
+			_, err := manager.Verify(issueAt(t, manager, clock, test.verifyAfter))
+			if test.valid && err != nil {
+				t.Fatalf("expected token to be valid, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestJWTManagerRequiresTimeClaims(t *testing.T) {
+	clock := newFakeClock()
+	manager := newTestJWTManager(t, clock)
+	now := clock.Now()
+
+	complete := jwt.StandardClaims{
+		Subject:   "alice",
+		Issuer:    "pracgrpc",
+		Audience:  "pracgrpc",
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(testTokenDuration).Unix(),
+	}
+
+	tests := []struct {
+		name   string
+		modify func(claims *jwt.StandardClaims)
+	}{
+		{"no exp", func(claims *jwt.StandardClaims) { claims.ExpiresAt = 0 }},
+		{"no nbf", func(claims *jwt.StandardClaims) { claims.NotBefore = 0 }},
+		{"no iat", func(claims *jwt.StandardClaims) { claims.IssuedAt = 0 }},
+		{"nbf beyond skew", func(claims *jwt.StandardClaims) { claims.NotBefore = now.Add(time.Minute).Unix() }},
+		{"iat beyond skew", func(claims *jwt.StandardClaims) { claims.IssuedAt = now.Add(time.Minute).Unix() }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			standard := complete
+			test.modify(&standard)
+
+			key := manager.keyRing.Active()
+			signed := signTestToken(t, key, key.ID, UserClaims{StandardClaims: standard, Username: "alice"})
+
+			if _, err := manager.Verify(signed); err == nil {
+				t.Fatal("expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestJWTManagerRevokeUserUsesClock(t *testing.T) {
+	clock := newFakeClock()
+	manager := newTestJWTManager(t, clock)
+
+	before := issueAt(t, manager, clock, time.Second)
+	if err := manager.RevokeUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Verify(before); err == nil {
+		t.Fatal("expected token issued before revocation to be rejected")
+	}
+
+	after := issueAt(t, manager, clock, time.Second)
+	if _, err := manager.Verify(after); err != nil {
+		t.Fatalf("expected token issued after revocation to be valid, got %v", err)
+	}
+}
+
+func TestJWTManagerIdentityClaims(t *testing.T) {
+	clock := newFakeClock()
+	manager := newTestJWTManager(t, clock)
+	key := manager.keyRing.Active()
+
+	tests := []struct {
+		name   string
+		modify func(claims *UserClaims)
+	}{
+		{"other issuer", func(claims *UserClaims) { claims.Issuer = "elsewhere" }},
+		{"no issuer", func(claims *UserClaims) { claims.Issuer = "" }},
+		{"other audience", func(claims *UserClaims) { claims.Audience = "elsewhere" }},
+		{"no subject", func(claims *UserClaims) { claims.Subject = "" }},
+		{"subject of another user", func(claims *UserClaims) { claims.Subject = "bob" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := validClaims(clock.Now())
+			claims.Issuer = "pracgrpc"
+			claims.Audience = "pracgrpc"
+			if _, err := manager.Verify(signTestToken(t, key, key.ID, claims)); err != nil {
+				t.Fatalf("Verify() of the unmodified claims error = %v", err)
+			}
+
+			test.modify(&claims)
+			if _, err := manager.Verify(signTestToken(t, key, key.ID, claims)); err == nil {
+				t.Fatal("expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestJWTManagerIssuesIdentityClaims(t *testing.T) {
+	clock := newFakeClock()
+	manager := newTestJWTManager(t, clock)
+
+	claims, err := manager.Verify(issueAt(t, manager, clock, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alice" || claims.Issuer != "pracgrpc" || claims.Audience != "pracgrpc" {
+		t.Fatalf("claims = %+v, want sub alice, iss and aud pracgrpc", claims.StandardClaims)
+	}
+	if claims.IssuedAt != clock.Now().Unix() || claims.NotBefore != claims.IssuedAt {
+		t.Fatalf("iat = %d and nbf = %d, want both %d", claims.IssuedAt, claims.NotBefore, clock.Now().Unix())
+	}
+}
+
+func TestTokenClientCredentials(t *testing.T) {
+	clientStore := model.NewInMemoryClientStore()
+	if err := clientStore.Save(model.NewClient("client-1", "secret", "billing", []string{"order:read", "order:write"})); err != nil {
+		t.Fatal(err)
+	}
+	jwtManager := newTestJWTManager(t, systemClock{})
+	server := NewAuthServer(model.NewInMemoryUserStore(), clientStore, nil, jwtManager, nil, nil, nil, newTestPolicyStore(t, testRoles), bcrypt.MinCost)
+
+	tests := []struct {
+		name string
+		req  *pb.TokenRequest
+		code codes.Code
+	}{
+		{"wrong secret", &pb.TokenRequest{GrantType: "client_credentials", ClientId: "client-1", ClientSecret: "wrong"}, codes.Unauthenticated},
+		{"unknown client", &pb.TokenRequest{GrantType: "client_credentials", ClientId: "client-2", ClientSecret: "secret"}, codes.Unauthenticated},
+		{"unsupported grant", &pb.TokenRequest{GrantType: "password", ClientId: "client-1", ClientSecret: "secret"}, codes.InvalidArgument},
+		{"ungranted scope", &pb.TokenRequest{GrantType: "client_credentials", ClientId: "client-1", ClientSecret: "secret", Scopes: []string{"order:admin"}}, codes.PermissionDenied},
+	}
+	for _, test := range tests {
+		if _, err := server.Token(context.Background(), test.req); status.Code(err) != test.code {
+			t.Errorf("%s: Token() error = %v, want %v", test.name, err, test.code)
+		}
+	}
+
+	res, err := server.Token(context.Background(), &pb.TokenRequest{GrantType: "client_credentials", ClientId: "client-1", ClientSecret: "secret", Scopes: []string{"order:read"}})
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if res.GetTokenType() != "Bearer" || res.GetExpiresIn() != int64(jwtManager.tokenDuration.Seconds()) {
+		t.Fatalf("Token() = %v, want a bearer token with its lifetime", res)
+	}
+
+	claims, err := jwtManager.Verify(res.GetAccessToken())
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	principal := principalFromClaims(claims)
+	if principal.Username != "client-1" || !principal.Machine || !reflect.DeepEqual(principal.Scopes, []string{"order:read"}) {
+		t.Fatalf("principal = %+v, want client-1 holding order:read", principal)
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	clock := newFakeClock()
+	jwtManager := newTestJWTManager(t, clock)
+	server := NewAuthServer(model.NewInMemoryUserStore(), model.NewInMemoryClientStore(), nil, jwtManager, nil, nil, nil, newTestPolicyStore(t, testRoles), bcrypt.MinCost)
+
+	token, err := jwtManager.Generate(&model.User{Username: "alice", Role: "user"}, []string{"product:read"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := server.Introspect(context.Background(), &pb.IntrospectRequest{Token: token})
+	if err != nil {
+		t.Fatalf("Introspect() error = %v", err)
+	}
+	now := clock.Now().Unix()
+	if !res.GetActive() || res.GetSub() != "alice" || res.GetRole() != "user" || res.GetIss() != "pracgrpc" || res.GetJti() == "" {
+		t.Fatalf("Introspect() = %v, want the active claims of alice", res)
+	}
+	if res.GetIat() != now || res.GetExp() != now+int64(jwtManager.tokenDuration.Seconds()) || !reflect.DeepEqual(res.GetScopes(), []string{"product:read"}) {
+		t.Fatalf("Introspect() = %v, want the times and scopes of the token", res)
+	}
+
+	revoked, err := jwtManager.Generate(&model.User{Username: "bob", Role: "user"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := jwtManager.Verify(revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jwtManager.Revoke(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := token
+	clock.Advance(jwtManager.tokenDuration + time.Minute)
+	for name, inactive := range map[string]string{"garbage": "not-a-token", "revoked": revoked, "expired": expired} {
+		res, err := server.Introspect(context.Background(), &pb.IntrospectRequest{Token: inactive})
+		if err != nil {
+			t.Fatalf("%s: Introspect() error = %v", name, err)
+		}
+		if res.GetActive() || res.GetSub() != "" || len(res.GetScopes()) != 0 {
+			t.Errorf("%s: Introspect() = %v, want an inactive token without claims", name, res)
+		}
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	clock := newFakeClock()
+	userStore := model.NewInMemoryUserStore()
+	user, err := model.NewUser("alice", "secret", "user", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestAuthServer(t, clock, userStore, NewLoginLimiter(3, time.Minute, time.Hour, clock))
+	ctx := context.Background()
+
+	login, err := server.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := server.jwtManager.Verify(login.GetAccessToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Refresh(ctx, &pb.RefreshRequest{RefreshToken: login.GetRefreshToken()}); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	_, err = server.Refresh(ctx, &pb.RefreshRequest{RefreshToken: login.GetRefreshToken()})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Refresh() with a used token error = %v, want %v", err, codes.Unauthenticated)
+	}
+
+	session, err := server.sessions.Find(claims.SessionID)
+	if err != nil || session != nil {
+		t.Fatalf("Find() = %v, %v, want the session to be revoked", session, err)
+	}
+	if err := server.sessions.Verify(claims.SessionID); err == nil {
+		t.Fatal("expected access tokens of the session to be rejected")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+}
+
+type KeyRing struct {
+	mutex   sync.RWMutex
+	active  *SigningKey
+	keys    map[string]*SigningKey
+	retired map[string]time.Time
+	clock   Clock
+}
+
+func NewSigningKey(privateKey crypto.Signer) (*SigningKey, error) {
+	var method jwt.SigningMethod
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve: %s", key.Curve.Params().Name)
+		}
+		method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", privateKey)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+
+	return &SigningKey{
+		ID:         base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Method:     method,
+		PrivateKey: privateKey,
+	}, nil
+}
+
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var privateKey crypto.Signer
+	var err error
+
+	switch algorithm {
+	case "RS256":
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate %s key: %w", algorithm, err)
+	}
+
+	return NewSigningKey(privateKey)
+}
+
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+
+	var privateKey interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse signing key: %w", err)
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %T", privateKey)
+	}
+	return NewSigningKey(signer)
+}
+
+func (key *SigningKey) PublicKey() crypto.PublicKey {
+	return key.PrivateKey.Public()
+}
+
+func NewKeyRing(active *SigningKey, clock Clock) *KeyRing {
+	return &KeyRing{
+		active:  active,
+		keys:    map[string]*SigningKey{active.ID: active},
+		retired: make(map[string]time.Time),
+		clock:   clock,
+	}
+}
+
+func (ring *KeyRing) Active() *SigningKey {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	return ring.active
+}
+
+func (ring *KeyRing) Find(id string) *SigningKey {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	if until, ok := ring.retired[id]; ok && ring.clock.Now().After(until) {
+		return nil
+	}
+	return ring.keys[id]
+}
+
+// Rotate makes next the signing key. The previous key keeps verifying tokens
+// for gracePeriod, which should be at least the lifetime of an access token.
+func (ring *KeyRing) Rotate(next *SigningKey, gracePeriod time.Duration) {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	ring.pruneRetired()
+
+	ring.retired[ring.active.ID] = ring.clock.Now().Add(gracePeriod)
+	ring.keys[next.ID] = next
+	delete(ring.retired, next.ID)
+	ring.active = next
+}
+
+func (ring *KeyRing) VerificationKeys() []*SigningKey {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	ring.pruneRetired()
+
+	keys := make([]*SigningKey, 0, len(ring.keys))
+	for _, key := range ring.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+func (ring *KeyRing) pruneRetired() {
+	now := ring.clock.Now()
+	for id, until := range ring.retired {
+		if now.After(until) {
+			delete(ring.retired, id)
+			delete(ring.keys, id)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/simp7/pracgrpc/model"
+	"testing"
+	"time"
+)
+
+func newTestSigningKey(t *testing.T, algorithm string) *SigningKey {
+	t.Helper()
+
+	key, err := GenerateSigningKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestJWTManagerSigningAlgorithms(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "ES256"} {
+		t.Run(algorithm, func(t *testing.T) {
+			key := newTestSigningKey(t, algorithm)
+			manager := NewJWTManager(NewKeyRing(key, systemClock{}), NewInMemoryRevocationStore(systemClock{}), time.Minute)
+
+			signed, err := manager.Generate(&model.User{Username: "alice", Role: "user"}, nil, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, _, err := new(jwt.Parser).ParseUnverified(signed, &UserClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Header["alg"] != algorithm || token.Header["kid"] != key.ID {
+				t.Fatalf("header = %v, want alg %s and kid %s", token.Header, algorithm, key.ID)
+			}
+
+			claims, err := manager.Verify(signed)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if claims.Username != "alice" {
+				t.Fatalf("Username = %q, want alice", claims.Username)
+			}
+		})
+	}
+}
+
+func TestGenerateSigningKeyRejectsUnknownAlgorithm(t *testing.T) {
+	if _, err := GenerateSigningKey("HS256"); err == nil {
+		t.Fatal("expected HS256 to be rejected")
+	}
+}
+
+func TestJWTManagerRejectsUnknownKeyID(t *testing.T) {
+	manager := NewJWTManager(NewKeyRing(newTestSigningKey(t, "ES256"), systemClock{}), NewInMemoryRevocationStore(systemClock{}), time.Minute)
+	stranger := NewJWTManager(NewKeyRing(newTestSigningKey(t, "ES256"), systemClock{}), NewInMemoryRevocationStore(systemClock{}), time.Minute)
+
+	signed, err := stranger.Generate(&model.User{Username: "alice", Role: "user"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Verify(signed); err == nil {
+		t.Fatal("expected a token of an unknown key to be rejected")
+	}
+}
+
+func TestJWTManagerRejectsForgedKeyID(t *testing.T) {
+	key := newTestSigningKey(t, "ES256")
+	manager := NewJWTManager(NewKeyRing(key, systemClock{}), NewInMemoryRevocationStore(systemClock{}), time.Minute)
+
+	// A token claiming the kid of the ring but signed with another key.
+	forger := newTestSigningKey(t, "ES256")
+	signed := signTestToken(t, forger, key.ID, validClaims(time.Now()))
+	if _, err := manager.Verify(signed); err == nil {
+		t.Fatal("expected a token signed by another key to be rejected")
+	}
+
+	// A token of the right kid but of another algorithm.
+	rsaKey := newTestSigningKey(t, "RS256")
+	signed = signTestToken(t, rsaKey, key.ID, validClaims(time.Now()))
+	if _, err := manager.Verify(signed); err == nil {
+		t.Fatal("expected a token of another algorithm to be rejected")
+	}
+
+	// The same claims signed by the ring's key are accepted.
+	if _, err := manager.Verify(signTestToken(t, key, key.ID, validClaims(time.Now()))); err != nil {
+		t.Fatalf("Verify() of a genuine token error = %v", err)
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	clock := newFakeClock()
+	previous := newTestSigningKey(t, "ES256")
+	ring := NewKeyRing(previous, clock)
+	manager := NewJWTManager(ring, NewInMemoryRevocationStore(clock), time.Minute, WithClock(clock))
+
+	signed, err := manager.Generate(&model.User{Username: "alice", Role: "user"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := newTestSigningKey(t, "RS256")
+	ring.Rotate(next, 2*time.Minute)
+	if ring.Active() != next {
+		t.Fatal("expected the next key to sign new tokens")
+	}
+	if _, err := manager.Verify(signed); err != nil {
+		t.Fatalf("Verify() of a token of the retired key error = %v", err)
+	}
+	if jwks := ring.JWKS(); len(jwks) != 2 {
+		t.Fatalf("JWKS() has %d keys, want both keys during the grace period", len(jwks))
+	}
+
+	clock.Advance(2*time.Minute + time.Second)
+	if ring.Find(previous.ID) != nil {
+		t.Fatal("expected a key past its grace period to be unknown")
+	}
+	for _, jwk := range ring.JWKS() {
+		if jwk.Kid == previous.ID {
+			t.Fatal("expected JWKS to drop a key past its grace period")
+		}
+	}
+	if ring.Find(next.ID) != next {
+		t.Fatal("expected the active key to stay known")
+	}
+}
+
+func TestSigningKeyJWK(t *testing.T) {
+	tests := map[string]string{"RS256": "RSA", "ES256": "EC"}
+	for algorithm, kty := range tests {
+		key := newTestSigningKey(t, algorithm)
+		jwk := key.JWK()
+		if jwk.Kid != key.ID || jwk.Alg != algorithm || jwk.Kty != kty || jwk.Use != "sig" {
+			t.Fatalf("JWK() = %+v, want kid %s, alg %s and kty %s", jwk, key.ID, algorithm, kty)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc/peer"
+	"net"
+	"sync"
+	"time"
+)
+
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginLimiter tracks failed logins per username and per peer address. After
+// freeAttempts failures every further failure locks the key for a delay that
+// doubles each time, up to maxDelay. Failures are forgotten once a key has
+// been quiet for resetAfter.
+type LoginLimiter struct {
+	mutex        sync.Mutex
+	attempts     map[string]*loginAttempts
+	freeAttempts int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	resetAfter   time.Duration
+	clock        Clock
+}
+
+func NewLoginLimiter(freeAttempts int, baseDelay time.Duration, maxDelay time.Duration, clock Clock) *LoginLimiter {
+	return &LoginLimiter{
+		attempts:     make(map[string]*loginAttempts),
+		freeAttempts: freeAttempts,
+		baseDelay:    baseDelay,
+		maxDelay:     maxDelay,
+		resetAfter:   2 * maxDelay,
+		clock:        clock,
+	}
+}
+
+// Check returns how long the caller has to wait before trying again, or zero
+// when neither the username nor the address is locked.
+func (limiter *LoginLimiter) Check(username string, address string) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.clock.Now()
+	var wait time.Duration
+	for _, key := range loginKeys(username, address) {
+		attempts, ok := limiter.attempts[key]
+		if ok && attempts.lockedUntil.Sub(now) > wait {
+			wait = attempts.lockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+func (limiter *LoginLimiter) Fail(username string, address string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.pruneExpired()
+
+	now := limiter.clock.Now()
+	for _, key := range loginKeys(username, address) {
+		attempts, ok := limiter.attempts[key]
+		if !ok {
+			attempts = &loginAttempts{}
+			limiter.attempts[key] = attempts
+		}
+		attempts.failures++
+		attempts.lastFailure = now
+		if attempts.failures > limiter.freeAttempts {
+			attempts.lockedUntil = now.Add(limiter.delay(attempts.failures - limiter.freeAttempts))
+		}
+	}
+}
+
+// Succeed clears the failures of username. The address keeps its record so a
+// client guessing many accounts is not let off by logging into its own.
+func (limiter *LoginLimiter) Succeed(username string) {
+	limiter.Unlock(username, "")
+}
+
+// Unlock forgets the failures of username and address, either of which may
+// be empty, and reports whether any were recorded.
+func (limiter *LoginLimiter) Unlock(username string, address string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	cleared := false
+	for _, key := range loginKeys(username, address) {
+		if _, ok := limiter.attempts[key]; ok {
+			delete(limiter.attempts, key)
+			cleared = true
+		}
+	}
+	return cleared
+}
+
+func (limiter *LoginLimiter) delay(excess int) time.Duration {
+	delay := limiter.baseDelay
+	for i := 1; i < excess && delay < limiter.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > limiter.maxDelay {
+		delay = limiter.maxDelay
+	}
+	return delay
+}
+
+func (limiter *LoginLimiter) pruneExpired() {
+	now := limiter.clock.Now()
+	for key, attempts := range limiter.attempts {
+		if now.After(attempts.lockedUntil) && now.Sub(attempts.lastFailure) > limiter.resetAfter {
+			delete(limiter.attempts, key)
+		}
+	}
+}
+
+func loginKeys(username string, address string) []string {
+	var keys []string
+	if username != "" {
+		keys = append(keys, "user:"+username)
+	}
+	if address != "" {
+		keys = append(keys, "peer:"+address)
+	}
+	return keys
+}
+
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	address := p.Addr.String()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLoginLimiter(2, time.Minute, 3*time.Minute, clock)
+
+	limiter.Fail("alice", "192.0.2.1")
+	limiter.Fail("alice", "192.0.2.1")
+	if wait := limiter.Check("alice", ""); wait != 0 {
+		t.Fatalf("Check() within the free attempts = %v, want 0", wait)
+	}
+
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		limiter.Fail("alice", "192.0.2.1")
+		if wait := limiter.Check("alice", ""); wait != want {
+			t.Fatalf("Check() = %v, want %v", wait, want)
+		}
+	}
+
+	clock.Advance(time.Minute)
+	if wait := limiter.Check("alice", ""); wait != 2*time.Minute {
+		t.Fatalf("Check() a minute later = %v, want %v", wait, 2*time.Minute)
+	}
+}
+
+func TestLoginLimiterForgetsQuietKeys(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLoginLimiter(0, time.Minute, time.Minute, clock)
+
+	limiter.Fail("alice", "")
+	clock.Advance(2*time.Minute + time.Second)
+
+	// Pruning runs on failures.
+	limiter.Fail("bob", "")
+	if _, ok := limiter.attempts["user:alice"]; ok {
+		t.Fatal("expected the failures of a quiet key to be forgotten")
+	}
+
+	limiter.Fail("bob", "")
+	if wait := limiter.Check("bob", ""); wait != time.Minute {
+		t.Fatalf("Check() = %v, want %v", wait, time.Minute)
+	}
+}
+
+func TestLoginLimiterKeys(t *testing.T) {
+	limiter := NewLoginLimiter(0, time.Minute, time.Hour, systemClock{})
+	limiter.Fail("alice", "192.0.2.1")
+
+	tests := []struct {
+		username string
+		address  string
+		locked   bool
+	}{
+		{"alice", "192.0.2.2", true},
+		{"bob", "192.0.2.1", true},
+		{"bob", "192.0.2.2", false},
+	}
+	for _, test := range tests {
+		if locked := limiter.Check(test.username, test.address) > 0; locked != test.locked {
+			t.Errorf("Check(%s, %s) locked = %v, want %v", test.username, test.address, locked, test.locked)
+		}
+	}
+
+	limiter.Succeed("alice")
+	if limiter.Check("alice", "") > 0 {
+		t.Fatal("expected a successful login to clear the user")
+	}
+	if limiter.Check("", "192.0.2.1") == 0 {
+		t.Fatal("expected a successful login to keep the address record")
+	}
+}
+
+func TestUnlockAccount(t *testing.T) {
+	limiter := NewLoginLimiter(0, time.Minute, time.Hour, systemClock{})
+	server := NewAdminServer(nil, limiter)
+	ctx := context.Background()
+
+	if _, err := server.UnlockAccount(ctx, &pb.UnlockAccountRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("UnlockAccount() without target error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	limiter.Fail("alice", "192.0.2.1")
+	res, err := server.UnlockAccount(ctx, &pb.UnlockAccountRequest{Username: "alice", Address: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.GetCleared() || limiter.Check("alice", "192.0.2.1") > 0 {
+		t.Fatal("expected UnlockAccount() to clear the lockout")
+	}
+
+	res, err = server.UnlockAccount(ctx, &pb.UnlockAccountRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetCleared() {
+		t.Fatal("expected nothing to clear on a second unlock")
+	}
+}
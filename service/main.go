@@ -1,23 +1,104 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"github.com/simp7/pracgrpc/model"
 	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"github.com/simp7/pracgrpc/model/sqlitestore"
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 const (
-	port          = ":50051"
-	secretKey     = "secret"
-	tokenDuration = 15 * time.Minute
+	port                 = ":50051"
+	tokenDuration        = 15 * time.Minute
+	refreshTokenDuration = 7 * 24 * time.Hour
 )
 
+var (
+	signingKeyPath    = flag.String("signing-key", "", "PEM file holding the RSA or P-256 key used to sign access tokens")
+	signingAlgorithm  = flag.String("signing-alg", "ES256", "algorithm of generated signing keys (RS256 or ES256)")
+	keyRotationPeriod = flag.Duration("key-rotation", 24*time.Hour, "how often a new signing key is generated, 0 disables rotation")
+	tokenIssuer       = flag.String("token-issuer", "pracgrpc", "iss claim of issued access tokens, tokens from other issuers are rejected")
+	tokenAudience     = flag.String("token-audience", "pracgrpc", "aud claim of issued access tokens, tokens for other audiences are rejected")
+	tokenClockSkew    = flag.Duration("token-clock-skew", 30*time.Second, "clock difference tolerated when checking token times")
+	userStoreBackend  = flag.String("user-store", "memory", "backend storing users and clients (memory, file or sqlite)")
+	userStorePath     = flag.String("user-store-path", "users.db", "location of the file or sqlite user store")
+	basicAuthEnabled  = flag.Bool("basic-auth", true, "accept HTTP Basic credentials on protected RPCs")
+	tlsCertFile       = flag.String("tls-cert", "", "server certificate file, TLS is disabled when empty")
+	tlsKeyFile        = flag.String("tls-key", "", "server private key file")
+	tlsClientCAFile   = flag.String("tls-client-ca", "", "CA certificate used to verify client certificates")
+	tlsClientAuth     = flag.String("tls-client-auth", "none", "client certificate mode (none, request or require)")
+	tlsReloadPeriod   = flag.Duration("tls-reload", 30*time.Second, "how often certificate files are checked for changes, 0 disables watching")
+	metricsAddress    = flag.String("metrics-addr", "", "address serving expvar metrics on /debug/vars, disabled when empty")
+	policyPath        = flag.String("policy", "policy.yaml", "YAML or JSON file mapping methods to the scopes they require and roles to the scopes they grant")
+	policyReload      = flag.Duration("policy-reload", 10*time.Second, "how often the policy file is checked for changes, 0 disables watching")
+	loginFreeAttempts = flag.Int("login-free-attempts", 5, "failed logins allowed per user or address before backoff starts")
+	loginBackoff      = flag.Duration("login-backoff", time.Second, "lockout after the first failed login beyond the free attempts, doubled on each further failure")
+	loginMaxLockout   = flag.Duration("login-max-lockout", 15*time.Minute, "longest lockout imposed after repeated failed logins")
+	challengeTimeout  = flag.Duration("second-factor-timeout", 5*time.Minute, "how long a login may wait for its second factor")
+	challengeAttempts = flag.Int("second-factor-attempts", 5, "wrong second factor codes allowed per login")
+	impersonationTTL  = flag.Duration("impersonation-duration", 5*time.Minute, "lifetime of tokens admins obtain to act as another user")
+	bcryptCost        = flag.Int("bcrypt-cost", bcrypt.DefaultCost, "bcrypt cost of new password hashes, weaker hashes are upgraded on login")
+	passwordMinLength = flag.Int("password-min-length", 8, "minimum length of user passwords")
+	passwordClasses   = flag.Int("password-min-classes", 3, "how many of lower case, upper case, digits and symbols a password must mix")
+	passwordDenylist  = flag.String("password-denylist", "", "file listing rejected passwords, one per line")
+)
+
+// newStores opens the user store and the client store kept along with it.
+func newStores() (model.UserStore, model.ClientStore, error) {
+	switch *userStoreBackend {
+	case "memory":
+		return model.NewInMemoryUserStore(), model.NewInMemoryClientStore(), nil
+	case "file":
+		userStore, err := model.NewFileUserStore(*userStorePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		clientStore, err := model.NewFileClientStore(*userStorePath + ".clients")
+		if err != nil {
+			userStore.Close()
+			return nil, nil, err
+		}
+		return userStore, clientStore, nil
+	case "sqlite":
+		userStore, err := sqlitestore.NewUserStore(*userStorePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return userStore, userStore.Clients(), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown user store backend: %s", *userStoreBackend)
+	}
+}
+
+// newAPIKeyStore keeps API keys next to the user store, so they last as long
+// as the users do.
+func newAPIKeyStore() (APIKeyStore, error) {
+	if *userStoreBackend == "memory" {
+		log.Println("api keys are kept in memory and will not survive a restart")
+		return NewInMemoryAPIKeyStore(), nil
+	}
+	return NewFileAPIKeyStore(*userStorePath + ".apikeys")
+}
+
 func createUser(userStore model.UserStore, username, password, role string) error {
-	user, err := model.NewUser(username, password, role)
+	existing, err := userStore.Find(username)
+	if err != nil || existing != nil {
+		return err
+	}
+
+	user, err := model.NewUser(username, password, role, *bcryptCost)
 	if err != nil {
 		return err
 	}
@@ -32,16 +113,98 @@ func seedUsers(userStore model.UserStore) error {
 	return createUser(userStore, "user1", "secret", "user")
 }
 
-func accessibleRoles() map[string][]string {
-	return map[string][]string{
-		"/ecommerce.ProductInfo/addProduct":      {"admin"},
-		"/ecommerce.OrderManagement/createOrder": {"admin", "user"},
+func loadPasswordPolicy() (*model.PasswordPolicy, error) {
+	if *bcryptCost < bcrypt.MinCost || *bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
 	}
+
+	policy := model.NewPasswordPolicy(*passwordMinLength, *passwordClasses)
+	if *passwordDenylist != "" {
+		if err := policy.LoadDenylist(*passwordDenylist); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+func signingKey() (*SigningKey, error) {
+	if *signingKeyPath != "" {
+		return LoadSigningKey(*signingKeyPath)
+	}
+	return GenerateSigningKey(*signingAlgorithm)
+}
+
+func scheduleKeyRotation(keyRing *KeyRing, rotationPeriod time.Duration) {
+	if rotationPeriod <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(rotationPeriod) {
+			key, err := GenerateSigningKey(*signingAlgorithm)
+			if err != nil {
+				log.Printf("cannot rotate signing key: %v", err)
+				continue
+			}
+			keyRing.Rotate(key, tokenDuration)
+			log.Printf("signing key rotated: %s", key.ID)
+		}
+	}()
+}
+
+func reloadOnSignal(reloader *CertReloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			log.Println("SIGHUP received, reloading certificates")
+			reloader.Reload()
+		}
+	}()
+}
+
+func serveMetrics(address string) {
+	go func() {
+		if err := http.ListenAndServe(address, nil); err != nil {
+			log.Printf("failed to serve metrics: %v", err)
+		}
+	}()
 }
 
 func main() {
-	userStore := model.NewInMemoryUserStore()
-	jwtManager := NewJWTManager(secretKey, tokenDuration)
+	flag.Parse()
+
+	if *impersonationTTL <= 0 || *impersonationTTL > tokenDuration {
+		log.Fatalf("impersonation duration must be positive and at most %v", tokenDuration)
+	}
+
+	key, err := signingKey()
+	if err != nil {
+		log.Fatal("cannot load signing key: ", err)
+	}
+	clock := systemClock{}
+	keyRing := NewKeyRing(key, clock)
+	scheduleKeyRotation(keyRing, *keyRotationPeriod)
+
+	passwordPolicy, err := loadPasswordPolicy()
+	if err != nil {
+		log.Fatal("cannot load password policy: ", err)
+	}
+
+	userStore, clientStore, err := newStores()
+	if err != nil {
+		log.Fatal("cannot open user store: ", err)
+	}
+	jwtManager := NewJWTManager(
+		keyRing,
+		NewInMemoryRevocationStore(clock),
+		tokenDuration,
+		WithIssuer(*tokenIssuer),
+		WithAudience(*tokenAudience),
+		WithClockSkew(*tokenClockSkew),
+		WithClock(clock),
+	)
 
 	if err := seedUsers(userStore); err != nil {
 		log.Fatal("cannot seed users: ", err)
@@ -49,21 +212,84 @@ func main() {
 		log.Println("seed users successfully")
 	}
 
-	authServer := NewAuthServer(userStore, jwtManager)
-	interceptor := NewAuthInterceptor(jwtManager, accessibleRoles())
+	refreshTokenManager := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(clock), refreshTokenDuration, clock)
+	sessionManager := NewSessionManager(NewInMemorySessionStore(clock), refreshTokenManager, clock)
+
+	loginLimiter := NewLoginLimiter(*loginFreeAttempts, *loginBackoff, *loginMaxLockout, clock)
+	passwordVerifier, err := NewPasswordVerifier(userStore, loginLimiter, *bcryptCost)
+	if err != nil {
+		log.Fatal("cannot create password verifier: ", err)
+	}
+
+	policyStore, err := NewPolicyStore(*policyPath)
+	if err != nil {
+		log.Fatal("cannot load policy: ", err)
+	}
+
+	secondFactors := NewSecondFactorManager(userStore, loginLimiter, *challengeTimeout, *challengeAttempts, clock)
+	authServer := NewAuthServer(userStore, clientStore, passwordVerifier, jwtManager, refreshTokenManager, sessionManager, secondFactors, policyStore, *bcryptCost)
+	userAdminServer := NewUserAdminServer(userStore, clientStore, jwtManager, sessionManager, policyStore, passwordPolicy, *bcryptCost, *impersonationTTL)
+
+	apiKeyStore, err := newAPIKeyStore()
+	if err != nil {
+		log.Fatal("cannot open api key store: ", err)
+	}
+	apiKeyManager := NewAPIKeyManager(apiKeyStore, policyStore)
+
+	interceptorOpts := []InterceptorOption{WithAPIKeys(apiKeyManager), WithSessions(sessionManager)}
+	if *basicAuthEnabled {
+		interceptorOpts = append(interceptorOpts, WithBasicAuth(passwordVerifier))
+	}
+	if *tlsClientCAFile != "" {
+		interceptorOpts = append(interceptorOpts, WithCertificateIdentity())
+	}
+	interceptor := NewAuthInterceptor(jwtManager, policyStore, interceptorOpts...)
 
 	opts := []grpc.ServerOption{
 		grpc.UnaryInterceptor(interceptor.Unary()),
 		grpc.StreamInterceptor(interceptor.Stream()),
 	}
 
+	var certReloader *CertReloader
+	if *tlsCertFile != "" {
+		certReloader, err = NewCertReloader(*tlsCertFile, *tlsKeyFile)
+		if err != nil {
+			log.Fatal("cannot load TLS certificate: ", err)
+		}
+
+		tlsConfig, err := serverTLSConfig(certReloader, *tlsClientCAFile, *tlsClientAuth)
+		if err != nil {
+			log.Fatal("cannot load TLS config: ", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+
+		reloadOnSignal(certReloader)
+		if *tlsReloadPeriod > 0 {
+			go certReloader.Watch(*tlsReloadPeriod, nil)
+		}
+	}
+
+	if *metricsAddress != "" {
+		serveMetrics(*metricsAddress)
+	}
+
 	s := grpc.NewServer(opts...)
 
 	pb.RegisterProductInfoServer(s, &server{})
 	pb.RegisterOrderManagementServer(s, &server{})
 	pb.RegisterAuthServiceServer(s, authServer)
+	pb.RegisterUserAdminServer(s, userAdminServer)
+	pb.RegisterAPIKeyAdminServer(s, NewAPIKeyAdminServer(apiKeyManager))
+	pb.RegisterAdminServer(s, NewAdminServer(certReloader, loginLimiter))
 	reflection.Register(s)
 
+	if err := policyStore.SetServices(s.GetServiceInfo()); err != nil {
+		log.Fatal("invalid policy: ", err)
+	}
+	if *policyReload > 0 {
+		go policyStore.Watch(*policyReload, nil)
+	}
+
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+	"github.com/simp7/pracgrpc/model"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"log"
+	"time"
+)
+
+var errIncorrectCredentials = errors.New("incorrect username/password")
+
+// PasswordVerifier checks passwords for Login and Basic authentication while
+// throttling guesses through a LoginLimiter.
+type PasswordVerifier struct {
+	userStore model.UserStore
+	limiter   *LoginLimiter
+	dummyUser *model.User
+}
+
+func NewPasswordVerifier(userStore model.UserStore, limiter *LoginLimiter, cost int) (*PasswordVerifier, error) {
+	password, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	dummyUser, err := model.NewUser("", password.String(), "", cost)
+	if err != nil {
+		return nil, err
+	}
+	return &PasswordVerifier{userStore, limiter, dummyUser}, nil
+}
+
+// Verify returns the user owning username and password, or
+// errIncorrectCredentials. Unknown users are checked against a dummy hash so
+// both cases take as long as a bcrypt comparison. The failures of a user with
+// a second factor are kept until SecondFactorManager sees it pass.
+func (verifier *PasswordVerifier) Verify(ctx context.Context, username string, password string) (*model.User, error) {
+	address := peerAddress(ctx)
+	if wait := verifier.limiter.Check(username, address); wait > 0 {
+		return nil, lockedOut(wait)
+	}
+
+	user, err := verifier.userStore.Find(username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	if user == nil {
+		verifier.dummyUser.IsCorrectPassword(password)
+	}
+	if user == nil || !user.IsCorrectPassword(password) {
+		// Unknown usernames only count against the address, so guessing
+		// names cannot grow the limiter without bound.
+		limited := username
+		if user == nil {
+			limited = ""
+		}
+		verifier.limiter.Fail(limited, address)
+		log.Printf("failed login for %s from %s", username, address)
+		return nil, errIncorrectCredentials
+	}
+
+	if !user.TOTPEnabled {
+		verifier.limiter.Succeed(username)
+	}
+	return user, nil
+}
+
+func lockedOut(wait time.Duration) error {
+	st := status.New(codes.ResourceExhausted, "too many failed login attempts")
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/simp7/pracgrpc/model"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestPasswordVerifier(t *testing.T, userStore model.UserStore) *PasswordVerifier {
+	t.Helper()
+
+	verifier, err := NewPasswordVerifier(userStore, NewLoginLimiter(3, time.Minute, time.Hour, systemClock{}), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier
+}
+
+func contextFrom(address string) context.Context {
+	addr := &net.TCPAddr{IP: net.ParseIP(address), Port: 50051}
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+}
+
+func TestPasswordVerifierLocksOut(t *testing.T) {
+	userStore := model.NewInMemoryUserStore()
+	user, err := model.NewUser("alice", "secret", "user", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	verifier := newTestPasswordVerifier(t, userStore)
+	ctx := contextFrom("192.0.2.1")
+
+	for i := 0; i < 4; i++ {
+		if _, err := verifier.Verify(ctx, "alice", "wrong"); !errors.Is(err, errIncorrectCredentials) {
+			t.Fatalf("Verify() attempt %d error = %v, want %v", i+1, err, errIncorrectCredentials)
+		}
+	}
+
+	_, err = verifier.Verify(contextFrom("192.0.2.2"), "alice", "secret")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Verify() of a locked user error = %v, want %v", err, codes.ResourceExhausted)
+	}
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	if retryInfo == nil || retryInfo.GetRetryDelay().AsDuration() <= 0 {
+		t.Fatalf("lockout error details = %v, want a retry delay", status.Convert(err).Details())
+	}
+}
+
+func TestPasswordVerifierCountsUnknownUsersByAddress(t *testing.T) {
+	limiter := NewLoginLimiter(3, time.Minute, time.Hour, systemClock{})
+	verifier, err := NewPasswordVerifier(model.NewInMemoryUserStore(), limiter, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := contextFrom("192.0.2.1")
+	for _, username := range []string{"a", "b", "c", "d"} {
+		verifier.Verify(ctx, username, "guess")
+	}
+
+	if len(limiter.attempts) != 1 {
+		t.Fatalf("limiter holds %d records, want only the address", len(limiter.attempts))
+	}
+	if limiter.Check("e", "192.0.2.1") <= 0 {
+		t.Fatal("expected the guessing address to be locked")
+	}
+	if limiter.Check("e", "192.0.2.2") > 0 {
+		t.Fatal("expected another address to stay unlocked")
+	}
+}
+
+func TestLoginReportsBadCredentialsAsUnauthenticated(t *testing.T) {
+	userStore := model.NewInMemoryUserStore()
+	server := NewAuthServer(userStore, model.NewInMemoryClientStore(), newTestPasswordVerifier(t, userStore), nil, nil, nil, nil, newTestPolicyStore(t, testRoles), bcrypt.MinCost)
+
+	_, err := server.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Login() error = %v, want %v", err, codes.Unauthenticated)
+	}
+}
+
+func TestLoginRehashesWeakHashes(t *testing.T) {
+	userStore := model.NewInMemoryUserStore()
+	user, err := model.NewUser("alice", "secret", "user", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	jwtManager := newTestJWTManager(t, systemClock{})
+	refreshTokens := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(systemClock{}), time.Hour, systemClock{})
+	cost := bcrypt.MinCost + 1
+	server := NewAuthServer(userStore, model.NewInMemoryClientStore(), newTestPasswordVerifier(t, userStore), jwtManager, refreshTokens, NewSessionManager(NewInMemorySessionStore(systemClock{}), refreshTokens, systemClock{}), nil, newTestPolicyStore(t, testRoles), cost)
+
+	if _, err := server.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err = userStore.Find("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.NeedsRehash(cost) || !user.IsCorrectPassword("secret") {
+		t.Fatal("expected Login() to store the password hashed with the current cost")
+	}
+}
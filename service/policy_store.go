@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"github.com/simp7/pracgrpc/model"
+	"google.golang.org/grpc"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type AccessPolicy interface {
+	Scopes(method string) ([]string, bool)
+	IsPublic(method string) bool
+	DenyByDefault() bool
+	RoleScopes(role string) ([]string, bool)
+	Snapshot() *model.Policy
+	BlocksImpersonation(method string) bool
+}
+
+type PolicyStore struct {
+	path     string
+	mutex    sync.Mutex
+	services map[string]grpc.ServiceInfo
+	policy   atomic.Pointer[model.Policy]
+}
+
+func NewPolicyStore(path string) (*PolicyStore, error) {
+	policy, err := model.LoadPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &PolicyStore{path: path}
+	store.policy.Store(policy)
+	return store, nil
+}
+
+// Snapshot returns the current policy, which a later reload does not change.
+// Use it to answer several questions about one request consistently.
+func (store *PolicyStore) Snapshot() *model.Policy {
+	return store.policy.Load()
+}
+
+func (store *PolicyStore) Scopes(method string) ([]string, bool) {
+	return store.policy.Load().Scopes(method)
+}
+
+func (store *PolicyStore) IsPublic(method string) bool {
+	return store.policy.Load().IsPublic(method)
+}
+
+func (store *PolicyStore) DenyByDefault() bool {
+	return store.policy.Load().DenyByDefault()
+}
+
+func (store *PolicyStore) RoleScopes(role string) ([]string, bool) {
+	return store.policy.Load().RoleScopes(role)
+}
+
+func (store *PolicyStore) BlocksImpersonation(method string) bool {
+	return store.policy.Load().BlocksImpersonation(method)
+}
+
+// SetServices validates the current policy against the registered services
+// and keeps them to validate every later reload.
+func (store *PolicyStore) SetServices(services map[string]grpc.ServiceInfo) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	policy := store.policy.Load()
+	if err := policy.Validate(services); err != nil {
+		return err
+	}
+	store.services = services
+	reportUncovered(policy, services)
+	return nil
+}
+
+func (store *PolicyStore) Reload() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	policy, err := model.LoadPolicy(store.path)
+	if err == nil && store.services != nil {
+		err = policy.Validate(store.services)
+	}
+	if err != nil {
+		log.Printf("cannot reload policy %s: %v", store.path, err)
+		return fmt.Errorf("cannot reload policy: %w", err)
+	}
+
+	store.policy.Store(policy)
+	log.Printf("policy reloaded: %s", store.path)
+	if store.services != nil {
+		reportUncovered(policy, store.services)
+	}
+	return nil
+}
+
+func (store *PolicyStore) Watch(interval time.Duration, done <-chan struct{}) {
+	watchFiles([]string{store.path}, interval, done, func() {
+		store.Reload()
+	})
+}
+
+func reportUncovered(policy *model.Policy, services map[string]grpc.ServiceInfo) {
+	action := "allowed without authentication"
+	if policy.DenyByDefault() {
+		action = "denied"
+	}
+
+	for _, method := range policy.Uncovered(services) {
+		log.Printf("method %s has no policy entry and is %s", method, action)
+	}
+}
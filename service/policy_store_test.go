@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/simp7/pracgrpc/model"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"google.golang.org/grpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRoles grants users read access and admins every scope the tests need.
+const testRoles = "roles:\n" +
+	"  user:\n    scopes: [product:read, password:change]\n" +
+	"  admin:\n    inherits: [user]\n    scopes: [product:write, order:admin, user:admin]\n"
+
+func writeTestPolicy(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestPolicyStore(t *testing.T, content string) *PolicyStore {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	writeTestPolicy(t, path, content)
+	store, err := NewPolicyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func productInfoServices() map[string]grpc.ServiceInfo {
+	server := grpc.NewServer()
+	pb.RegisterProductInfoServer(server, &pb.UnimplementedProductInfoServer{})
+	return server.GetServiceInfo()
+}
+
+func TestPolicyStoreReload(t *testing.T) {
+	store := newTestPolicyStore(t, testRoles+"rules:\n  /ecommerce.ProductInfo/addProduct: [product:write]\n")
+	if err := store.SetServices(productInfoServices()); err != nil {
+		t.Fatalf("SetServices() error = %v", err)
+	}
+	snapshot := store.Snapshot()
+
+	writeTestPolicy(t, store.path, testRoles+"rules:\n  /ecommerce.ProductInfo/addProduct: [product:read, product:write]\n")
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if scopes, _ := store.Scopes("/ecommerce.ProductInfo/addProduct"); len(scopes) != 2 {
+		t.Fatalf("Scopes() = %v, want the reloaded scopes", scopes)
+	}
+	if scopes, _ := snapshot.Scopes("/ecommerce.ProductInfo/addProduct"); len(scopes) != 1 {
+		t.Fatalf("snapshot Scopes() = %v, want the scopes it was taken with", scopes)
+	}
+
+	tests := map[string]string{
+		"unparsable":      "rules: [",
+		"invalid pattern": testRoles + "rules:\n  /ecommerce.ProductInfo: [product:write]\n",
+		"unknown method":  testRoles + "rules:\n  /ecommerce.ProductInfo/removeProduct: [product:write]\n",
+		"unknown service": testRoles + "rules:\n  /ecommerce.Nothing/*: [product:write]\n",
+		"ungranted scope": testRoles + "rules:\n  /ecommerce.ProductInfo/addProduct: [product:delete]\n",
+	}
+	for name, content := range tests {
+		writeTestPolicy(t, store.path, content)
+		if err := store.Reload(); err == nil {
+			t.Errorf("%s: expected Reload() to fail", name)
+		}
+		if scopes, _ := store.Scopes("/ecommerce.ProductInfo/addProduct"); len(scopes) != 2 {
+			t.Errorf("%s: Scopes() = %v, want the previous policy to stay active", name, scopes)
+		}
+	}
+}
+
+func TestPolicyStoreWatch(t *testing.T) {
+	store := newTestPolicyStore(t, testRoles+"rules:\n  /ecommerce.ProductInfo/addProduct: [product:write]\n")
+
+	done := make(chan struct{})
+	defer close(done)
+	go store.Watch(10*time.Millisecond, done)
+
+	writeTestPolicy(t, store.path, testRoles+"rules:\n  /ecommerce.ProductInfo/addProduct: [product:read]\n")
+
+	// The watcher may take its first snapshot after the write, so keep
+	// touching the file until it notices a change.
+	deadline := time.Now().Add(5 * time.Second)
+	for touched := time.Now(); ; {
+		if scopes, _ := store.Scopes("/ecommerce.ProductInfo/addProduct"); len(scopes) == 1 && scopes[0] == "product:read" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the watcher to reload the changed policy")
+		}
+		touched = touched.Add(time.Second)
+		if err := os.Chtimes(store.path, touched, touched); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestShippedPolicyDeniesByDefault(t *testing.T) {
+	policy, err := model.LoadPolicy("policy.yaml")
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+	if !policy.DenyByDefault() {
+		t.Fatal("expected the shipped policy to deny uncovered methods")
+	}
+	if !policy.IsPublic("/ecommerce.AuthService/Login") {
+		t.Fatal("expected Login to be public")
+	}
+	if policy.IsPublic("/ecommerce.AuthService/Introspect") {
+		t.Fatal("expected Introspect to need credentials")
+	}
+	if scopes, _ := policy.Scopes("/ecommerce.AuthService/Introspect"); !reflect.DeepEqual(scopes, []string{"token:introspect"}) {
+		t.Fatalf("Introspect scopes = %v, want only token:introspect", scopes)
+	}
+	if user, _ := policy.RoleScopes("user"); (&Principal{Scopes: user}).HasScope("token:introspect") {
+		t.Fatal("expected plain users not to introspect tokens")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"time"
+)
+
+const (
+	AuthMethodJWT         = "jwt"
+	AuthMethodBasic       = "basic"
+	AuthMethodCertificate = "certificate"
+	AuthMethodAPIKey      = "apikey"
+)
+
+const (
+	ScopeOrderAdmin = "order:admin"
+	ScopeUserAdmin  = "user:admin"
+)
+
+type Principal struct {
+	Username   string
+	Role       string
+	Scopes     []string
+	TokenID    string
+	ExpiresAt  time.Time
+	AuthMethod string
+	Machine    bool
+	SessionID  string
+	Actor      string
+}
+
+type principalKey struct{}
+
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+func (principal *Principal) HasScope(scope string) bool {
+	for _, granted := range principal.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (stream *principalStream) Context() context.Context {
+	return stream.ctx
+}
+
+func principalFromClaims(claims *UserClaims) *Principal {
+	principal := &Principal{
+		Username:   claims.Username,
+		Role:       claims.Role,
+		Scopes:     claims.Scopes,
+		TokenID:    claims.Id,
+		ExpiresAt:  time.Unix(claims.ExpiresAt, 0),
+		AuthMethod: AuthMethodJWT,
+		Machine:    claims.Machine,
+		SessionID:  claims.SessionID,
+	}
+	if claims.Actor != nil {
+		principal.Actor = claims.Actor.Subject
+	}
+	return principal
+}
+
+// grantScopes returns the scopes of role limited to requested, or all of them
+// when nothing is requested.
+func grantScopes(policy AccessPolicy, role string, requested []string) ([]string, error) {
+	available, ok := policy.RoleScopes(role)
+	if !ok {
+		return nil, fmt.Errorf("role %s is not defined by the policy", role)
+	}
+	return narrowScopes(available, requested)
+}
+
+func narrowScopes(available []string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return available, nil
+	}
+
+	principal := &Principal{Scopes: available}
+	var granted []string
+	for _, scope := range requested {
+		if !principal.HasScope(scope) {
+			return nil, fmt.Errorf("scope %s is not available", scope)
+		}
+		granted = append(granted, scope)
+	}
+	return granted, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"github.com/simp7/pracgrpc/model"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *testServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func TestAuthInterceptorPropagatesPrincipal(t *testing.T) {
+	interceptor, jwtManager, userStore := newTestInterceptor(t, true)
+
+	user, err := userStore.Find("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwtManager.Generate(user, []string{"product:write"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		authorization string
+		authMethod    string
+	}{
+		"bearer": {"Bearer " + token, AuthMethodJWT},
+		"basic":  {basicCredentials("alice", "secret"), AuthMethodBasic},
+	}
+	for name, test := range tests {
+		var got *Principal
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			got, _ = PrincipalFromContext(ctx)
+			return nil, nil
+		}
+		info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+		if _, err := interceptor.Unary()(withAuthorization(test.authorization), nil, info, handler); err != nil {
+			t.Fatalf("%s: error = %v", name, err)
+		}
+		if got == nil || got.Username != "alice" || got.Role != "admin" || got.AuthMethod != test.authMethod {
+			t.Fatalf("%s: principal = %+v, want alice authenticated by %s", name, got, test.authMethod)
+		}
+		if test.authMethod == AuthMethodJWT && got.TokenID == "" {
+			t.Fatalf("%s: principal has no token id", name)
+		}
+	}
+
+	var streamed *Principal
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		streamed, _ = PrincipalFromContext(stream.Context())
+		return nil
+	}
+	stream := &testServerStream{ctx: withAuthorization("Bearer " + token)}
+	info := &grpc.StreamServerInfo{FullMethod: testMethod}
+	if err := interceptor.Stream()(nil, stream, info, streamHandler); err != nil {
+		t.Fatal(err)
+	}
+	if streamed == nil || streamed.Username != "alice" {
+		t.Fatalf("stream principal = %+v, want alice", streamed)
+	}
+}
+
+func TestPrincipalFromContext(t *testing.T) {
+	if _, ok := PrincipalFromContext(context.Background()); ok {
+		t.Fatal("expected no principal in an empty context")
+	}
+	if _, ok := PrincipalFromContext(ContextWithPrincipal(context.Background(), nil)); ok {
+		t.Fatal("expected a nil principal to be reported as missing")
+	}
+}
+
+func TestLogoutNeedsTokenPrincipal(t *testing.T) {
+	server := NewAuthServer(nil, nil, nil, nil, nil, nil, nil, nil, 0)
+
+	if _, err := server.Logout(context.Background(), &pb.LogoutRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Logout() without principal error = %v, want %v", err, codes.Unauthenticated)
+	}
+
+	ctx := ContextWithPrincipal(context.Background(), &Principal{Username: "alice", AuthMethod: AuthMethodBasic})
+	if _, err := server.Logout(ctx, &pb.LogoutRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Logout() of a basic principal error = %v, want %v", err, codes.FailedPrecondition)
+	}
+}
+
+func TestGrantScopes(t *testing.T) {
+	policy := newTestPolicyStore(t, testRoles)
+
+	tests := []struct {
+		name      string
+		role      string
+		requested []string
+		want      []string
+		wantErr   bool
+	}{
+		{"every scope of the role", "user", nil, []string{"password:change", "product:read"}, false},
+		{"requested subset", "admin", []string{"product:write"}, []string{"product:write"}, false},
+		{"inherited scope", "admin", []string{"product:read"}, []string{"product:read"}, false},
+		{"scope of another role", "user", []string{"product:write"}, nil, true},
+		{"undefined role", "guest", nil, nil, true},
+	}
+	for _, test := range tests {
+		scopes, err := grantScopes(policy, test.role, test.requested)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: grantScopes() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(scopes, test.want) {
+			t.Errorf("%s: grantScopes() = %v, want %v", test.name, scopes, test.want)
+		}
+	}
+}
+
+func TestLoginAndRefreshKeepRequestedScopes(t *testing.T) {
+	userStore := model.NewInMemoryUserStore()
+	user, err := model.NewUser("alice", "secret", "admin", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	jwtManager := newTestJWTManager(t, systemClock{})
+	refreshTokens := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(systemClock{}), time.Hour, systemClock{})
+	server := NewAuthServer(userStore, model.NewInMemoryClientStore(), newTestPasswordVerifier(t, userStore), jwtManager, refreshTokens, NewSessionManager(NewInMemorySessionStore(systemClock{}), refreshTokens, systemClock{}), nil, newTestPolicyStore(t, testRoles), bcrypt.MinCost)
+
+	req := &pb.LoginRequest{Username: "alice", Password: "secret", Scopes: []string{"product:delete"}}
+	if _, err := server.Login(context.Background(), req); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Login() with an ungranted scope error = %v, want %v", err, codes.PermissionDenied)
+	}
+
+	req.Scopes = []string{"product:read"}
+	login, err := server.Login(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if !reflect.DeepEqual(login.GetScopes(), req.Scopes) {
+		t.Fatalf("Login() scopes = %v, want %v", login.GetScopes(), req.Scopes)
+	}
+
+	refreshed, err := server.Refresh(context.Background(), &pb.RefreshRequest{RefreshToken: login.GetRefreshToken()})
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	claims, err := jwtManager.Verify(refreshed.GetAccessToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(claims.Scopes, req.Scopes) {
+		t.Fatalf("refreshed scopes = %v, want %v", claims.Scopes, req.Scopes)
+	}
+}
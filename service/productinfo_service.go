@@ -11,13 +11,14 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
 )
 
 type server struct {
-	productMap          map[string]*pb.Product
-	orderMap            map[string]*pb.Order
-	combinedShipmentMap map[string]*pb.CombinedShipment
-	batchSize           int
+	mutex      sync.RWMutex
+	productMap map[string]*pb.Product
+	orderMap   map[string]*pb.Order
+	batchSize  int
 	pb.UnimplementedProductInfoServer
 	pb.UnimplementedOrderManagementServer
 }
@@ -28,6 +29,9 @@ func (s *server) AddProduct(ctx context.Context, in *pb.Product) (*pb.ProductID,
 		return nil, status.Errorf(codes.Internal, "Error while generating Product ID: %v", err)
 	}
 	in.Id = out.String()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if s.productMap == nil {
 		s.productMap = make(map[string]*pb.Product)
 	}
@@ -37,6 +41,8 @@ func (s *server) AddProduct(ctx context.Context, in *pb.Product) (*pb.ProductID,
 }
 
 func (s *server) GetProduct(ctx context.Context, in *pb.ProductID) (*pb.Product, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	value, exists := s.productMap[in.Value]
 	if exists {
 		return value, status.New(codes.OK, "").Err()
@@ -46,28 +52,40 @@ func (s *server) GetProduct(ctx context.Context, in *pb.ProductID) (*pb.Product,
 
 func (s *server) GetOrder(ctx context.Context, orderId *wrapperspb.StringValue) (*pb.Order, error) {
 	log.Print("value", orderId.Value)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	ord, exists := s.orderMap[orderId.Value]
-	if exists {
+	if exists && canAccessOrder(ctx, ord) {
 		return ord, status.New(codes.OK, "").Err()
 	}
 	return nil, status.Errorf(codes.NotFound, "Order does not exist")
 }
 
 func (s *server) SearchOrders(searchQuery *wrapperspb.StringValue, stream pb.OrderManagement_SearchOrdersServer) error {
+	s.mutex.RLock()
+	var matches []*pb.Order
 	for key, order := range s.orderMap {
+		if !canAccessOrder(stream.Context(), order) {
+			continue
+		}
 		log.Print(key, order)
 		for _, itemStr := range order.Items {
 			log.Print(itemStr)
 			if strings.Contains(itemStr, searchQuery.Value) {
-				err := stream.Send(order)
-				if err != nil {
-					return fmt.Errorf("error sending message to stream: %v", err)
-				}
+				matches = append(matches, order)
 				log.Print("Matching Order Found: ", key)
 				break
 			}
 		}
 	}
+	s.mutex.RUnlock()
+
+	for _, order := range matches {
+		err := stream.Send(order)
+		if err != nil {
+			return fmt.Errorf("error sending message to stream: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -77,7 +95,10 @@ func (s *server) CreateOrder(ctx context.Context, order *pb.Order) (*wrapperspb.
 		return nil, status.Errorf(codes.Internal, "Error while generating Product ID: %v", err)
 	}
 	order.Id = out.String()
+	order.Owner = orderOwner(ctx)
 
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if s.orderMap == nil {
 		s.orderMap = make(map[string]*pb.Order)
 	}
@@ -93,24 +114,24 @@ func (s *server) UpdateOrders(stream pb.OrderManagement_UpdateOrdersServer) erro
 		if err == io.EOF {
 			return stream.SendAndClose(wrapperspb.String("Orders processed " + ordersStr))
 		}
-		s.orderMap[order.Id] = order
-		log.Printf("Order ID ", order.Id, ": Updated")
+		if err != nil {
+			return err
+		}
+		if err := s.updateOrder(stream.Context(), order); err != nil {
+			return err
+		}
+		log.Printf("Order ID %s: Updated", order.Id)
 		ordersStr += order.Id + ", "
 	}
 }
 
 func (s *server) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
-	s.combinedShipmentMap = make(map[string]*pb.CombinedShipment)
+	combinedShipmentMap := make(map[string]*pb.CombinedShipment)
 	batchMarker := 0
 	for {
 		orderId, err := stream.Recv()
-		s.combinedShipmentMap[orderId.Value] = &pb.CombinedShipment{
-			Id:         "...",
-			Status:     "..",
-			OrdersList: []*pb.Order{},
-		}
 		if err == io.EOF {
-			for _, comb := range s.combinedShipmentMap {
+			for _, comb := range combinedShipmentMap {
 				stream.Send(comb)
 			}
 			return nil
@@ -118,14 +139,73 @@ func (s *server) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) er
 		if err != nil {
 			return err
 		}
+		order, err := s.findOrder(stream.Context(), orderId.Value)
+		if err != nil {
+			return err
+		}
+		combinedShipmentMap[orderId.Value] = &pb.CombinedShipment{
+			Id:         "...",
+			Status:     "..",
+			OrdersList: []*pb.Order{order},
+		}
 		if batchMarker == s.batchSize {
-			for _, comb := range s.combinedShipmentMap {
+			for _, comb := range combinedShipmentMap {
 				stream.Send(comb)
 			}
 			batchMarker = 0
-			s.combinedShipmentMap = make(map[string]*pb.CombinedShipment)
+			combinedShipmentMap = make(map[string]*pb.CombinedShipment)
 		} else {
 			batchMarker++
 		}
 	}
 }
+
+func (s *server) findOrder(ctx context.Context, id string) (*pb.Order, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	order, exists := s.orderMap[id]
+	if !exists || !canAccessOrder(ctx, order) {
+		return nil, status.Errorf(codes.NotFound, "Order does not exist")
+	}
+	return order, nil
+}
+
+// updateOrder replaces an order the caller can access, or creates it for the
+// caller when it does not exist yet. The owner is never taken from the request.
+func (s *server) updateOrder(ctx context.Context, order *pb.Order) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.orderMap == nil {
+		s.orderMap = make(map[string]*pb.Order)
+	}
+
+	existing, exists := s.orderMap[order.Id]
+	if exists && !canAccessOrder(ctx, existing) {
+		return status.Errorf(codes.NotFound, "Order does not exist")
+	}
+	if exists {
+		order.Owner = existing.Owner
+	} else {
+		order.Owner = orderOwner(ctx)
+	}
+	s.orderMap[order.Id] = order
+	return nil
+}
+
+func orderOwner(ctx context.Context) string {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return principal.Username
+}
+
+// canAccessOrder reports whether the caller may see order. Callers with the
+// order:admin scope see every order and other users only their own.
+func canAccessOrder(ctx context.Context, order *pb.Order) bool {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return order.Owner == ""
+	}
+	return principal.HasScope(ScopeOrderAdmin) || order.Owner == principal.Username
+}
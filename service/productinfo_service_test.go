@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"testing"
+)
+
+type searchOrdersStream struct {
+	testServerStream
+	orders []*pb.Order
+}
+
+func (stream *searchOrdersStream) Send(order *pb.Order) error {
+	stream.orders = append(stream.orders, order)
+	return nil
+}
+
+type updateOrdersStream struct {
+	testServerStream
+	orders []*pb.Order
+}
+
+func (stream *updateOrdersStream) Recv() (*pb.Order, error) {
+	if len(stream.orders) == 0 {
+		return nil, io.EOF
+	}
+	order := stream.orders[0]
+	stream.orders = stream.orders[1:]
+	return order, nil
+}
+
+func (stream *updateOrdersStream) SendAndClose(*wrapperspb.StringValue) error {
+	return nil
+}
+
+// contextAs returns the context of a caller holding role, where only admins
+// hold the order:admin scope.
+func contextAs(username string, role string) context.Context {
+	principal := &Principal{Username: username, Role: role, AuthMethod: AuthMethodJWT}
+	if role == "admin" {
+		principal.Scopes = []string{ScopeOrderAdmin}
+	}
+	return ContextWithPrincipal(context.Background(), principal)
+}
+
+func createTestOrder(t *testing.T, s *server, ctx context.Context, order *pb.Order) string {
+	t.Helper()
+
+	id, err := s.CreateOrder(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id.GetValue()
+}
+
+func TestCreateOrderRecordsCaller(t *testing.T) {
+	s := &server{}
+	alice := contextAs("alice", "user")
+
+	id := createTestOrder(t, s, alice, &pb.Order{Items: []string{"book"}, Owner: "mallory"})
+
+	order, err := s.GetOrder(alice, wrapperspb.String(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.GetOwner() != "alice" {
+		t.Fatalf("Owner = %q, want alice", order.GetOwner())
+	}
+}
+
+func TestGetOrderOwnership(t *testing.T) {
+	s := &server{}
+	id := createTestOrder(t, s, contextAs("alice", "user"), &pb.Order{Items: []string{"book"}})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"owner", contextAs("alice", "user"), codes.OK},
+		{"admin", contextAs("root", "admin"), codes.OK},
+		{"other user", contextAs("bob", "user"), codes.NotFound},
+		{"anonymous", context.Background(), codes.NotFound},
+	}
+	for _, test := range tests {
+		if _, err := s.GetOrder(test.ctx, wrapperspb.String(id)); status.Code(err) != test.code {
+			t.Errorf("%s: GetOrder() error = %v, want %v", test.name, err, test.code)
+		}
+	}
+}
+
+func TestSearchOrdersOnlyReturnsOwnOrders(t *testing.T) {
+	s := &server{}
+	createTestOrder(t, s, contextAs("alice", "user"), &pb.Order{Items: []string{"book"}})
+	createTestOrder(t, s, contextAs("bob", "user"), &pb.Order{Items: []string{"book"}})
+
+	tests := map[string]int{"user": 1, "admin": 2}
+	for role, want := range tests {
+		stream := &searchOrdersStream{testServerStream: testServerStream{ctx: contextAs("alice", role)}}
+		if err := s.SearchOrders(wrapperspb.String("book"), stream); err != nil {
+			t.Fatal(err)
+		}
+		if len(stream.orders) != want {
+			t.Errorf("%s: SearchOrders() found %d orders, want %d", role, len(stream.orders), want)
+		}
+	}
+}
+
+func TestUpdateOrdersKeepsOwner(t *testing.T) {
+	s := &server{}
+	aliceOrder := createTestOrder(t, s, contextAs("alice", "user"), &pb.Order{Items: []string{"book"}})
+	bob := contextAs("bob", "user")
+
+	stream := &updateOrdersStream{
+		testServerStream: testServerStream{ctx: bob},
+		orders:           []*pb.Order{{Id: aliceOrder, Items: []string{"stolen"}, Owner: "bob"}},
+	}
+	if err := s.UpdateOrders(stream); status.Code(err) != codes.NotFound {
+		t.Fatalf("UpdateOrders() of another user's order error = %v, want %v", err, codes.NotFound)
+	}
+
+	admin := contextAs("root", "admin")
+	stream = &updateOrdersStream{
+		testServerStream: testServerStream{ctx: admin},
+		orders: []*pb.Order{
+			{Id: aliceOrder, Items: []string{"books"}, Owner: "root"},
+			{Id: "new", Items: []string{"pen"}, Owner: "alice"},
+		},
+	}
+	if err := s.UpdateOrders(stream); err != nil {
+		t.Fatalf("UpdateOrders() error = %v", err)
+	}
+
+	owners := map[string]string{aliceOrder: "alice", "new": "root"}
+	for id, want := range owners {
+		order, err := s.GetOrder(admin, wrapperspb.String(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.GetOwner() != want {
+			t.Errorf("Owner of %s = %q, want %q", id, order.GetOwner(), want)
+		}
+	}
+}
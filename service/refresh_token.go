@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenExpired  = errors.New("refresh token is expired")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been used")
+)
+
+// RefreshTokenReusedError is returned by Rotate for a token that was already
+// used. It names the family revoked because of it, so its session can be
+// ended too.
+type RefreshTokenReusedError struct {
+	FamilyID string
+}
+
+func (err *RefreshTokenReusedError) Error() string {
+	return ErrRefreshTokenReused.Error()
+}
+
+func (err *RefreshTokenReusedError) Is(target error) bool {
+	return target == ErrRefreshTokenReused
+}
+
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	Username  string
+	Scopes    []string
+	ExpiresAt time.Time
+	Used      bool
+}
+
+type RefreshTokenStore interface {
+	Save(token *RefreshToken) error
+	Find(hash string) (*RefreshToken, error)
+	Rotate(hash string) (*RefreshToken, error)
+	RevokeFamily(familyID string) error
+	RevokeUser(username string) error
+}
+
+type InMemoryRefreshTokenStore struct {
+	mutex  sync.Mutex
+	tokens map[string]*RefreshToken
+	clock  Clock
+}
+
+type RefreshTokenManager struct {
+	store         RefreshTokenStore
+	tokenDuration time.Duration
+	clock         Clock
+}
+
+func NewInMemoryRefreshTokenStore(clock Clock) *InMemoryRefreshTokenStore {
+	return &InMemoryRefreshTokenStore{
+		tokens: make(map[string]*RefreshToken),
+		clock:  clock,
+	}
+}
+
+func (store *InMemoryRefreshTokenStore) Save(token *RefreshToken) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.pruneExpired()
+
+	copied := *token
+	store.tokens[token.Hash] = &copied
+	return nil
+}
+
+func (store *InMemoryRefreshTokenStore) Find(hash string) (*RefreshToken, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	token := store.tokens[hash]
+	if token == nil {
+		return nil, nil
+	}
+
+	copied := *token
+	return &copied, nil
+}
+
+// Rotate marks the token as used and returns its previous state. A token that
+// was already used signals theft, so the whole family is revoked.
+func (store *InMemoryRefreshTokenStore) Rotate(hash string) (*RefreshToken, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	token := store.tokens[hash]
+	if token == nil {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	if token.Used {
+		store.revokeFamily(token.FamilyID)
+		return nil, &RefreshTokenReusedError{token.FamilyID}
+	}
+
+	if store.clock.Now().After(token.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	token.Used = true
+	copied := *token
+	return &copied, nil
+}
+
+func (store *InMemoryRefreshTokenStore) RevokeFamily(familyID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.revokeFamily(familyID)
+	return nil
+}
+
+func (store *InMemoryRefreshTokenStore) RevokeUser(username string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for hash, token := range store.tokens {
+		if token.Username == username {
+			delete(store.tokens, hash)
+		}
+	}
+	return nil
+}
+
+func (store *InMemoryRefreshTokenStore) revokeFamily(familyID string) {
+	for hash, token := range store.tokens {
+		if token.FamilyID == familyID {
+			delete(store.tokens, hash)
+		}
+	}
+}
+
+func (store *InMemoryRefreshTokenStore) pruneExpired() {
+	now := store.clock.Now()
+	for hash, token := range store.tokens {
+		if now.After(token.ExpiresAt) {
+			delete(store.tokens, hash)
+		}
+	}
+}
+
+func NewRefreshTokenManager(store RefreshTokenStore, tokenDuration time.Duration, clock Clock) *RefreshTokenManager {
+	return &RefreshTokenManager{store, tokenDuration, clock}
+}
+
+// Generate starts a token family, which is the session familyID.
+func (manager *RefreshTokenManager) Generate(username string, familyID string, scopes []string) (string, error) {
+	return manager.issue(username, familyID, scopes)
+}
+
+// Rotate exchanges refreshToken for a new one of the same family and returns
+// the state of the exchanged token alongside it.
+func (manager *RefreshTokenManager) Rotate(refreshToken string) (*RefreshToken, string, error) {
+	previous, err := manager.store.Rotate(hashRefreshToken(refreshToken))
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := manager.issue(previous.Username, previous.FamilyID, previous.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	return previous, token, nil
+}
+
+func (manager *RefreshTokenManager) Revoke(refreshToken string) error {
+	token, err := manager.store.Find(hashRefreshToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return ErrRefreshTokenNotFound
+	}
+	return manager.store.RevokeFamily(token.FamilyID)
+}
+
+func (manager *RefreshTokenManager) RevokeFamily(familyID string) error {
+	return manager.store.RevokeFamily(familyID)
+}
+
+func (manager *RefreshTokenManager) RevokeUser(username string) error {
+	return manager.store.RevokeUser(username)
+}
+
+func (manager *RefreshTokenManager) issue(username string, familyID string, scopes []string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("cannot generate refresh token: %w", err)
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(buf)
+
+	err := manager.store.Save(&RefreshToken{
+		Hash:      hashRefreshToken(refreshToken),
+		FamilyID:  familyID,
+		Username:  username,
+		Scopes:    scopes,
+		ExpiresAt: manager.clock.Now().Add(manager.tokenDuration),
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot save refresh token: %w", err)
+	}
+
+	return refreshToken, nil
+}
+
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	manager := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(systemClock{}), time.Hour, systemClock{})
+
+	first, err := manager.Generate("alice", "session-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous, second, err := manager.Rotate(first)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if previous.Username != "alice" || second == "" || second == first {
+		t.Fatalf("Rotate() = %q, %q, want alice and a new token", previous.Username, second)
+	}
+
+	if _, _, err := manager.Rotate(second); err != nil {
+		t.Fatalf("Rotate() of the rotated token error = %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	manager := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(systemClock{}), time.Hour, systemClock{})
+
+	first, err := manager.Generate("alice", "session-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := manager.Rotate(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := manager.Rotate(first); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Rotate() of a used token error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if _, _, err := manager.Rotate(second); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Fatalf("Rotate() of a revoked family error = %v, want %v", err, ErrRefreshTokenNotFound)
+	}
+}
+
+func TestRefreshTokenFamiliesAreIndependent(t *testing.T) {
+	manager := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(systemClock{}), time.Hour, systemClock{})
+
+	stolen, err := manager.Generate("alice", "session-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := manager.Generate("alice", "session-2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager.Rotate(stolen)
+	manager.Rotate(stolen)
+
+	if _, _, err := manager.Rotate(other); err != nil {
+		t.Fatalf("Rotate() of another family error = %v", err)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	clock := newFakeClock()
+	manager := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(clock), time.Hour, clock)
+
+	token, err := manager.Generate("alice", "session-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour + time.Second)
+
+	if _, _, err := manager.Rotate(token); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Fatalf("Rotate() of an expired token error = %v, want %v", err, ErrRefreshTokenExpired)
+	}
+	if _, _, err := manager.Rotate("unknown"); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Fatalf("Rotate() of an unknown token error = %v, want %v", err, ErrRefreshTokenNotFound)
+	}
+}
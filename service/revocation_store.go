@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type RevocationStore interface {
+	Revoke(tokenID string, expiresAt time.Time) error
+	RevokeUser(username string, issuedBefore time.Time, expiresAt time.Time) error
+	IsRevoked(tokenID string, username string, issuedAt time.Time) (bool, error)
+}
+
+type userRevocation struct {
+	issuedBefore time.Time
+	expiresAt    time.Time
+}
+
+type InMemoryRevocationStore struct {
+	mutex  sync.RWMutex
+	tokens map[string]time.Time
+	users  map[string]userRevocation
+	clock  Clock
+}
+
+func NewInMemoryRevocationStore(clock Clock) *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]userRevocation),
+		clock:  clock,
+	}
+}
+
+func (store *InMemoryRevocationStore) Revoke(tokenID string, expiresAt time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.pruneExpired()
+
+	store.tokens[tokenID] = expiresAt
+	return nil
+}
+
+// RevokeUser rejects tokens of username issued before issuedBefore. Token iat
+// claims have second precision, so tokens issued within the same second stay
+// valid; otherwise a login right after the revocation would be rejected.
+func (store *InMemoryRevocationStore) RevokeUser(username string, issuedBefore time.Time, expiresAt time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.pruneExpired()
+
+	store.users[username] = userRevocation{issuedBefore.Truncate(time.Second), expiresAt}
+	return nil
+}
+
+func (store *InMemoryRevocationStore) IsRevoked(tokenID string, username string, issuedAt time.Time) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if _, ok := store.tokens[tokenID]; ok {
+		return true, nil
+	}
+
+	revocation, ok := store.users[username]
+	if ok && issuedAt.Before(revocation.issuedBefore) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (store *InMemoryRevocationStore) pruneExpired() {
+	now := store.clock.Now()
+	for tokenID, expiresAt := range store.tokens {
+		if now.After(expiresAt) {
+			delete(store.tokens, tokenID)
+		}
+	}
+	for username, revocation := range store.users {
+		if now.After(revocation.expiresAt) {
+			delete(store.users, username)
+		}
+	}
+}
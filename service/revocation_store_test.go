@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"github.com/simp7/pracgrpc/model"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+	"time"
+)
+
+func TestRevocationStoreRevokesTokenIDs(t *testing.T) {
+	store := NewInMemoryRevocationStore(systemClock{})
+	issuedAt := time.Now()
+
+	if err := store.Revoke("revoked", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if revoked, _ := store.IsRevoked("revoked", "alice", issuedAt); !revoked {
+		t.Fatal("expected the revoked token id to be revoked")
+	}
+	if revoked, _ := store.IsRevoked("other", "alice", issuedAt); revoked {
+		t.Fatal("expected another token id to stay valid")
+	}
+}
+
+func TestRevocationStoreRevokesUsersBySecond(t *testing.T) {
+	store := NewInMemoryRevocationStore(systemClock{})
+	revokedAt := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+
+	if err := store.RevokeUser("alice", revokedAt, revokedAt.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// iat has second precision, so a token issued in the second of the
+	// revocation cannot be told apart from one issued right after it.
+	tests := []struct {
+		name     string
+		username string
+		issuedAt time.Time
+		revoked  bool
+	}{
+		{"issued a second before", "alice", revokedAt.Truncate(time.Second).Add(-time.Second), true},
+		{"issued in the same second", "alice", revokedAt.Truncate(time.Second), false},
+		{"issued after", "alice", revokedAt.Add(time.Second), false},
+		{"other user", "bob", revokedAt.Add(-time.Hour), false},
+	}
+	for _, test := range tests {
+		revoked, err := store.IsRevoked("token", test.username, test.issuedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != test.revoked {
+			t.Errorf("%s: IsRevoked() = %v, want %v", test.name, revoked, test.revoked)
+		}
+	}
+}
+
+func TestRevocationStorePrunesExpired(t *testing.T) {
+	store := NewInMemoryRevocationStore(systemClock{})
+	store.Revoke("expired", time.Now().Add(-time.Second))
+	store.RevokeUser("alice", time.Now(), time.Now().Add(-time.Second))
+
+	// Pruning runs on writes.
+	store.Revoke("other", time.Now().Add(time.Minute))
+
+	if len(store.tokens) != 1 || len(store.users) != 0 {
+		t.Fatalf("store holds %d tokens and %d users, want only the live token", len(store.tokens), len(store.users))
+	}
+}
+
+func TestLogoutRevokesAccessAndRefreshTokens(t *testing.T) {
+	userStore := model.NewInMemoryUserStore()
+	user, err := model.NewUser("alice", "secret", "user", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	jwtManager := newTestJWTManager(t, systemClock{})
+	refreshTokens := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(systemClock{}), time.Hour, systemClock{})
+	sessions := NewSessionManager(NewInMemorySessionStore(systemClock{}), refreshTokens, systemClock{})
+	server := NewAuthServer(userStore, model.NewInMemoryClientStore(), newTestPasswordVerifier(t, userStore), jwtManager, refreshTokens, sessions, nil, newTestPolicyStore(t, testRoles), bcrypt.MinCost)
+
+	login, err := server.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := jwtManager.Verify(login.GetAccessToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ContextWithPrincipal(context.Background(), principalFromClaims(claims))
+	if _, err := server.Logout(ctx, &pb.LogoutRequest{RefreshToken: login.GetRefreshToken()}); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	if _, err := jwtManager.Verify(login.GetAccessToken()); err == nil {
+		t.Fatal("expected the access token to be revoked")
+	}
+	if _, err := server.Refresh(context.Background(), &pb.RefreshRequest{RefreshToken: login.GetRefreshToken()}); err == nil {
+		t.Fatal("expected the refresh token to be revoked")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/simp7/pracgrpc/model"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrChallengeInvalid       = errors.New("second factor challenge is invalid or expired")
+	ErrSecondFactorIncorrect  = errors.New("second factor code is incorrect")
+	ErrTOTPAlreadyEnabled     = errors.New("totp is already enabled")
+	ErrTOTPNotEnrolled        = errors.New("totp enrollment has not been started")
+	ErrTOTPNotEnabled         = errors.New("totp is not enabled")
+	ErrSecondFactorUserAbsent = errors.New("user no longer exists")
+)
+
+// SecondFactorChallenge is handed out by Login when the password was correct
+// but the user still has to prove a second factor.
+type SecondFactorChallenge struct {
+	Username  string
+	Scopes    []string
+	ExpiresAt time.Time
+	Attempts  int
+}
+
+// SecondFactorManager enrolls users into TOTP and checks their codes. Checks
+// are serialized so a code cannot be used twice by concurrent requests, and
+// wrong codes count as failed logins so they cannot be guessed across
+// challenges.
+type SecondFactorManager struct {
+	mutex             sync.Mutex
+	userStore         model.UserStore
+	limiter           *LoginLimiter
+	challenges        map[string]*SecondFactorChallenge
+	challengeDuration time.Duration
+	maxAttempts       int
+	clock             Clock
+}
+
+func NewSecondFactorManager(userStore model.UserStore, limiter *LoginLimiter, challengeDuration time.Duration, maxAttempts int, clock Clock) *SecondFactorManager {
+	return &SecondFactorManager{
+		userStore:         userStore,
+		limiter:           limiter,
+		challenges:        make(map[string]*SecondFactorChallenge),
+		challengeDuration: challengeDuration,
+		maxAttempts:       maxAttempts,
+		clock:             clock,
+	}
+}
+
+// Challenge starts a second factor check for user, remembering the scopes
+// the login asked for.
+func (manager *SecondFactorManager) Challenge(user *model.User, scopes []string) (string, error) {
+	challenge, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	manager.pruneExpired()
+	manager.challenges[hashAPIKey(challenge)] = &SecondFactorChallenge{
+		Username:  user.Username,
+		Scopes:    scopes,
+		ExpiresAt: manager.clock.Now().Add(manager.challengeDuration),
+	}
+	return challenge, nil
+}
+
+// Verify completes challenge with a TOTP or recovery code sent from address.
+// A challenge is dropped once it succeeds or runs out of attempts.
+func (manager *SecondFactorManager) Verify(challenge string, code string, address string) (*model.User, *SecondFactorChallenge, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	hash := hashAPIKey(challenge)
+	pending := manager.challenges[hash]
+	if pending == nil || manager.clock.Now().After(pending.ExpiresAt) {
+		delete(manager.challenges, hash)
+		return nil, nil, ErrChallengeInvalid
+	}
+	if wait := manager.limiter.Check(pending.Username, address); wait > 0 {
+		return nil, nil, lockedOut(wait)
+	}
+
+	user, err := manager.findUser(pending.Username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.TOTPEnabled {
+		delete(manager.challenges, hash)
+		return nil, nil, ErrChallengeInvalid
+	}
+
+	if err := manager.checkCode(user, code, address); err != nil {
+		pending.Attempts++
+		if pending.Attempts >= manager.maxAttempts {
+			delete(manager.challenges, hash)
+		}
+		return nil, nil, err
+	}
+
+	delete(manager.challenges, hash)
+	manager.limiter.Succeed(user.Username)
+	return user, pending, nil
+}
+
+// Enroll gives username a new TOTP secret. It takes effect only after Confirm,
+// so an abandoned enrollment never locks the user out.
+func (manager *SecondFactorManager) Enroll(username string) (string, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	user, err := manager.findUser(username)
+	if err != nil {
+		return "", err
+	}
+	if user.TOTPEnabled {
+		return "", ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := model.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := manager.userStore.Update(user); err != nil {
+		return "", fmt.Errorf("cannot save totp secret: %w", err)
+	}
+	return secret, nil
+}
+
+// Confirm enables TOTP once the user proves the secret was imported, and
+// returns fresh recovery codes.
+func (manager *SecondFactorManager) Confirm(username string, code string, address string) ([]string, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if wait := manager.limiter.Check(username, address); wait > 0 {
+		return nil, lockedOut(wait)
+	}
+
+	user, err := manager.findUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if !user.VerifyTOTP(code, manager.clock.Now()) {
+		manager.fail(username, address)
+		return nil, ErrSecondFactorIncorrect
+	}
+	manager.limiter.Succeed(username)
+
+	codes, err := user.ResetRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	if err := manager.userStore.Update(user); err != nil {
+		return nil, fmt.Errorf("cannot enable totp: %w", err)
+	}
+	return codes, nil
+}
+
+// Disable turns TOTP off after checking a TOTP or recovery code.
+func (manager *SecondFactorManager) Disable(username string, code string, address string) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if wait := manager.limiter.Check(username, address); wait > 0 {
+		return lockedOut(wait)
+	}
+
+	user, err := manager.findUser(username)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	if err := manager.checkCode(user, code, address); err != nil {
+		return err
+	}
+	manager.limiter.Succeed(username)
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	if err := manager.userStore.Update(user); err != nil {
+		return fmt.Errorf("cannot disable totp: %w", err)
+	}
+	return nil
+}
+
+// checkCode accepts a TOTP code or consumes a recovery code, and saves the
+// user so neither can be replayed. A wrong code is a failed login of user
+// from address.
+func (manager *SecondFactorManager) checkCode(user *model.User, code string, address string) error {
+	if !user.VerifyTOTP(code, manager.clock.Now()) && !user.UseRecoveryCode(code) {
+		manager.fail(user.Username, address)
+		return ErrSecondFactorIncorrect
+	}
+	if err := manager.userStore.Update(user); err != nil {
+		return fmt.Errorf("cannot save second factor state: %w", err)
+	}
+	return nil
+}
+
+func (manager *SecondFactorManager) fail(username string, address string) {
+	manager.limiter.Fail(username, address)
+	log.Printf("failed second factor for %s from %s", username, address)
+}
+
+func (manager *SecondFactorManager) findUser(username string) (*model.User, error) {
+	user, err := manager.userStore.Find(username)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrSecondFactorUserAbsent
+	}
+	return user, nil
+}
+
+func (manager *SecondFactorManager) pruneExpired() {
+	now := manager.clock.Now()
+	for hash, challenge := range manager.challenges {
+		if now.After(challenge.ExpiresAt) {
+			delete(manager.challenges, hash)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/simp7/pracgrpc/model"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSecondFactorGuessesLockOutLogin(t *testing.T) {
+	const freeAttempts = 3
+
+	clock := newFakeClock()
+	userStore := model.NewInMemoryUserStore()
+	user, err := model.NewUser("alice", "secret", "user", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.TOTPSecret, err = model.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.TOTPEnabled = true
+	if err := userStore.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	limiter := NewLoginLimiter(freeAttempts, time.Minute, time.Hour, clock)
+	server := newTestAuthServer(t, clock, userStore, limiter)
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}})
+	login := func() (*pb.LoginResponse, error) {
+		return server.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "secret"})
+	}
+
+	pending, err := login()
+	if err != nil || !pending.GetSecondFactorRequired() {
+		t.Fatalf("Login() = %v, %v, want a second factor challenge", pending, err)
+	}
+
+	// Every cycle starts from a correct password and a fresh challenge, so only
+	// the limiter stands between the caller and guessing all codes.
+	for i := 0; i <= freeAttempts; i++ {
+		res, err := login()
+		if err != nil {
+			t.Fatalf("Login() #%d error = %v", i+1, err)
+		}
+		_, err = server.VerifySecondFactor(ctx, &pb.VerifySecondFactorRequest{Challenge: res.GetChallenge(), Code: "000000"})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("VerifySecondFactor() #%d error = %v, want %v", i+1, err, codes.Unauthenticated)
+		}
+	}
+
+	if _, err := login(); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Login() after lockout error = %v, want %v", err, codes.ResourceExhausted)
+	}
+
+	code, err := model.TOTPCode(user.TOTPSecret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.VerifySecondFactor(ctx, &pb.VerifySecondFactorRequest{Challenge: pending.GetChallenge(), Code: code})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("VerifySecondFactor() with correct code after lockout error = %v, want %v", err, codes.ResourceExhausted)
+	}
+
+	clock.Advance(2 * time.Minute)
+	res, err := login()
+	if err != nil {
+		t.Fatalf("Login() after lockout expired error = %v", err)
+	}
+	code, err = model.TOTPCode(user.TOTPSecret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := server.VerifySecondFactor(ctx, &pb.VerifySecondFactorRequest{Challenge: res.GetChallenge(), Code: code})
+	if err != nil || tokens.GetAccessToken() == "" {
+		t.Fatalf("VerifySecondFactor() after lockout expired = %v, %v, want tokens", tokens, err)
+	}
+}
+
+func newTestSecondFactors(t *testing.T, clock *fakeClock) (*SecondFactorManager, model.UserStore) {
+	t.Helper()
+
+	userStore := model.NewInMemoryUserStore()
+	user, err := model.NewUser("alice", "secret", "user", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewLoginLimiter(10, time.Minute, time.Hour, clock)
+	return NewSecondFactorManager(userStore, limiter, 5*time.Minute, 3, clock), userStore
+}
+
+func currentCode(t *testing.T, secret string, clock Clock) string {
+	t.Helper()
+
+	code, err := model.TOTPCode(secret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestSecondFactorEnrollment(t *testing.T) {
+	clock := newFakeClock()
+	manager, userStore := newTestSecondFactors(t, clock)
+
+	if _, err := manager.Confirm("alice", "000000", "192.0.2.1"); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Fatalf("Confirm() before Enroll() error = %v, want %v", err, ErrTOTPNotEnrolled)
+	}
+
+	secret, err := manager.Enroll("alice")
+	if err != nil {
+		t.Fatalf("Enroll() error = %v", err)
+	}
+	user, _ := userStore.Find("alice")
+	if user.TOTPEnabled {
+		t.Fatal("expected TOTP to stay disabled until it is confirmed")
+	}
+
+	if _, err := manager.Confirm("alice", "000000", "192.0.2.1"); !errors.Is(err, ErrSecondFactorIncorrect) {
+		t.Fatalf("Confirm() with a wrong code error = %v, want %v", err, ErrSecondFactorIncorrect)
+	}
+	recoveryCodes, err := manager.Confirm("alice", currentCode(t, secret, clock), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("Confirm() = %d recovery codes, want 10", len(recoveryCodes))
+	}
+	user, _ = userStore.Find("alice")
+	if !user.TOTPEnabled {
+		t.Fatal("expected Confirm() to enable TOTP")
+	}
+
+	if _, err := manager.Enroll("alice"); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Fatalf("Enroll() of an enabled user error = %v, want %v", err, ErrTOTPAlreadyEnabled)
+	}
+
+	if err := manager.Disable("alice", "000000", "192.0.2.1"); !errors.Is(err, ErrSecondFactorIncorrect) {
+		t.Fatalf("Disable() with a wrong code error = %v, want %v", err, ErrSecondFactorIncorrect)
+	}
+	if err := manager.Disable("alice", recoveryCodes[0], "192.0.2.1"); err != nil {
+		t.Fatalf("Disable() with a recovery code error = %v", err)
+	}
+	user, _ = userStore.Find("alice")
+	if user.TOTPEnabled || user.TOTPSecret != "" || len(user.RecoveryCodes) != 0 {
+		t.Fatalf("user = %+v, want every second factor removed", user)
+	}
+	if err := manager.Disable("alice", recoveryCodes[1], "192.0.2.1"); !errors.Is(err, ErrTOTPNotEnabled) {
+		t.Fatalf("Disable() of a disabled user error = %v, want %v", err, ErrTOTPNotEnabled)
+	}
+}
+
+func TestSecondFactorChallenge(t *testing.T) {
+	clock := newFakeClock()
+	manager, userStore := newTestSecondFactors(t, clock)
+	secret, err := manager.Enroll("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := manager.Confirm("alice", currentCode(t, secret, clock), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := userStore.Find("alice")
+
+	challenge, err := manager.Challenge(user, []string{"product:read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, pending, err := manager.Verify(challenge, recoveryCodes[0], "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Verify() with a recovery code error = %v", err)
+	}
+	if verified.Username != "alice" || len(pending.Scopes) != 1 || pending.Scopes[0] != "product:read" {
+		t.Fatalf("Verify() = %s with %v, want alice with the requested scopes", verified.Username, pending.Scopes)
+	}
+	if _, _, err := manager.Verify(challenge, recoveryCodes[1], "192.0.2.1"); !errors.Is(err, ErrChallengeInvalid) {
+		t.Fatalf("Verify() of a completed challenge error = %v, want %v", err, ErrChallengeInvalid)
+	}
+
+	challenge, err = manager.Challenge(user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := manager.Verify(challenge, recoveryCodes[0], "192.0.2.1"); !errors.Is(err, ErrSecondFactorIncorrect) {
+		t.Fatalf("Verify() with a used recovery code error = %v, want %v", err, ErrSecondFactorIncorrect)
+	}
+	manager.Verify(challenge, "000000", "192.0.2.1")
+	manager.Verify(challenge, "000000", "192.0.2.1")
+	if _, _, err := manager.Verify(challenge, recoveryCodes[1], "192.0.2.1"); !errors.Is(err, ErrChallengeInvalid) {
+		t.Fatalf("Verify() after too many attempts error = %v, want %v", err, ErrChallengeInvalid)
+	}
+
+	challenge, err = manager.Challenge(user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(5*time.Minute + time.Second)
+	if _, _, err := manager.Verify(challenge, recoveryCodes[1], "192.0.2.1"); !errors.Is(err, ErrChallengeInvalid) {
+		t.Fatalf("Verify() of an expired challenge error = %v, want %v", err, ErrChallengeInvalid)
+	}
+}
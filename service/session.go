@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"google.golang.org/grpc/metadata"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session has been revoked")
+)
+
+// Session is one login of a user. Its ID is the family of the refresh tokens
+// issued to it and the sid claim of its access tokens. Actor names the admin
+// behind an impersonation session.
+type Session struct {
+	ID          string
+	Username    string
+	Actor       string
+	UserAgent   string
+	PeerAddress string
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+}
+
+type SessionStore interface {
+	Save(session *Session) error
+	Find(id string) (*Session, error)
+	List(username string) ([]*Session, error)
+	Delete(id string) error
+	DeleteUser(username string) error
+	Touch(id string, usedAt time.Time, expiresAt time.Time) error
+}
+
+type InMemorySessionStore struct {
+	mutex    sync.RWMutex
+	sessions map[string]*Session
+	clock    Clock
+}
+
+type SessionManager struct {
+	store           SessionStore
+	refreshTokens   *RefreshTokenManager
+	sessionDuration time.Duration
+	clock           Clock
+}
+
+func NewInMemorySessionStore(clock Clock) *InMemorySessionStore {
+	return &InMemorySessionStore{
+		sessions: make(map[string]*Session),
+		clock:    clock,
+	}
+}
+
+func (store *InMemorySessionStore) Save(session *Session) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.pruneExpired()
+
+	copied := *session
+	store.sessions[session.ID] = &copied
+	return nil
+}
+
+func (store *InMemorySessionStore) Find(id string) (*Session, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	session := store.sessions[id]
+	if session == nil || store.clock.Now().After(session.ExpiresAt) {
+		return nil, nil
+	}
+
+	copied := *session
+	return &copied, nil
+}
+
+// List returns the live sessions of username, or of every user when username
+// is empty, oldest first.
+func (store *InMemorySessionStore) List(username string) ([]*Session, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	now := store.clock.Now()
+	var sessions []*Session
+	for _, session := range store.sessions {
+		if now.After(session.ExpiresAt) || (username != "" && session.Username != username) {
+			continue
+		}
+		copied := *session
+		sessions = append(sessions, &copied)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (store *InMemorySessionStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(store.sessions, id)
+	return nil
+}
+
+// DeleteUser removes the sessions of username, including those in which
+// username impersonates someone else.
+func (store *InMemorySessionStore) DeleteUser(username string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for id, session := range store.sessions {
+		if session.Username == username || session.Actor == username {
+			delete(store.sessions, id)
+		}
+	}
+	return nil
+}
+
+// Touch records a use of the session. A zero expiresAt keeps the expiry.
+func (store *InMemorySessionStore) Touch(id string, usedAt time.Time, expiresAt time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session := store.sessions[id]
+	if session == nil {
+		return ErrSessionNotFound
+	}
+	session.LastUsedAt = usedAt
+	if !expiresAt.IsZero() {
+		session.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (store *InMemorySessionStore) pruneExpired() {
+	now := store.clock.Now()
+	for id, session := range store.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(store.sessions, id)
+		}
+	}
+}
+
+// NewSessionManager creates sessions lasting as long as the refresh tokens of
+// refreshTokens, extended on every refresh.
+func NewSessionManager(store SessionStore, refreshTokens *RefreshTokenManager, clock Clock) *SessionManager {
+	return &SessionManager{store, refreshTokens, refreshTokens.tokenDuration, clock}
+}
+
+// Start records a new session for username, describing the client from the
+// metadata of ctx, and returns its first refresh token.
+func (manager *SessionManager) Start(ctx context.Context, username string, scopes []string) (*Session, string, error) {
+	session, err := manager.save(ctx, username, "", manager.sessionDuration)
+	if err != nil {
+		return nil, "", err
+	}
+
+	refreshToken, err := manager.refreshTokens.Generate(username, session.ID, scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, refreshToken, nil
+}
+
+// StartImpersonation records a session in which actor acts as username for
+// duration. It has no refresh token, so it cannot outlive duration, and it
+// ends along with the other sessions of either user.
+func (manager *SessionManager) StartImpersonation(ctx context.Context, username string, actor string, duration time.Duration) (*Session, error) {
+	return manager.save(ctx, username, actor, duration)
+}
+
+func (manager *SessionManager) save(ctx context.Context, username string, actor string, duration time.Duration) (*Session, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate session id: %w", err)
+	}
+
+	now := manager.clock.Now()
+	session := &Session{
+		ID:          id.String(),
+		Username:    username,
+		Actor:       actor,
+		UserAgent:   userAgent(ctx),
+		PeerAddress: peerAddress(ctx),
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(duration),
+	}
+	if err := manager.store.Save(session); err != nil {
+		return nil, fmt.Errorf("cannot save session: %w", err)
+	}
+	return session, nil
+}
+
+// Verify fails unless the session is still live, and records its use.
+func (manager *SessionManager) Verify(id string) error {
+	return manager.touch(id, time.Time{})
+}
+
+// Extend keeps the session alive for as long as a refresh token issued now.
+func (manager *SessionManager) Extend(id string) error {
+	return manager.touch(id, manager.clock.Now().Add(manager.sessionDuration))
+}
+
+func (manager *SessionManager) Find(id string) (*Session, error) {
+	return manager.store.Find(id)
+}
+
+func (manager *SessionManager) List(username string) ([]*Session, error) {
+	return manager.store.List(username)
+}
+
+// Revoke ends the session along with its refresh tokens. Access tokens carry
+// the session id, so they stop working as well.
+func (manager *SessionManager) Revoke(id string) error {
+	if err := manager.store.Delete(id); err != nil {
+		return err
+	}
+	return manager.refreshTokens.RevokeFamily(id)
+}
+
+func (manager *SessionManager) RevokeUser(username string) error {
+	if err := manager.store.DeleteUser(username); err != nil {
+		return err
+	}
+	return manager.refreshTokens.RevokeUser(username)
+}
+
+func (manager *SessionManager) touch(id string, expiresAt time.Time) error {
+	session, err := manager.store.Find(id)
+	if err != nil {
+		return fmt.Errorf("cannot find session: %w", err)
+	}
+	if session == nil {
+		return ErrSessionRevoked
+	}
+
+	err = manager.store.Touch(id, manager.clock.Now(), expiresAt)
+	if errors.Is(err, ErrSessionNotFound) {
+		return ErrSessionRevoked
+	}
+	return err
+}
+
+func userAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get("user-agent")
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/simp7/pracgrpc/model"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func newTestSessionManager(clock Clock) (*SessionManager, *RefreshTokenManager) {
+	refreshTokens := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(clock), time.Hour, clock)
+	return NewSessionManager(NewInMemorySessionStore(clock), refreshTokens, clock), refreshTokens
+}
+
+func TestSessionLifetime(t *testing.T) {
+	clock := newFakeClock()
+	sessions, refreshTokens := newTestSessionManager(clock)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "test-client"))
+	session, refreshToken, err := sessions.Start(ctx, "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.UserAgent != "test-client" || !session.ExpiresAt.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatalf("Start() = %+v, want the user agent and an hour of lifetime", session)
+	}
+
+	clock.Advance(50 * time.Minute)
+	if err := sessions.Extend(session.ID); err != nil {
+		t.Fatalf("Extend() error = %v", err)
+	}
+	clock.Advance(50 * time.Minute)
+	if err := sessions.Verify(session.ID); err != nil {
+		t.Fatalf("Verify() of an extended session error = %v", err)
+	}
+	found, err := sessions.Find(session.ID)
+	if err != nil || found == nil || !found.LastUsedAt.Equal(clock.Now()) {
+		t.Fatalf("Find() = %+v, %v, want the last use recorded", found, err)
+	}
+
+	if err := sessions.Revoke(session.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if err := sessions.Verify(session.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("Verify() of a revoked session error = %v, want %v", err, ErrSessionRevoked)
+	}
+	if _, _, err := refreshTokens.Rotate(refreshToken); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Fatalf("Rotate() of a revoked session error = %v, want %v", err, ErrRefreshTokenNotFound)
+	}
+
+	expired, _, err := sessions.Start(context.Background(), "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour + time.Second)
+	if err := sessions.Verify(expired.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("Verify() of an expired session error = %v, want %v", err, ErrSessionRevoked)
+	}
+}
+
+func TestSessionListAndRevokeUser(t *testing.T) {
+	clock := newFakeClock()
+	sessions, _ := newTestSessionManager(clock)
+
+	var ids []string
+	for _, username := range []string{"alice", "bob", "alice"} {
+		session, _, err := sessions.Start(context.Background(), username, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.ID)
+		clock.Advance(time.Second)
+	}
+
+	listed, err := sessions.List("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[0].ID != ids[0] || listed[1].ID != ids[2] {
+		t.Fatalf("List(alice) = %v, want the sessions of alice oldest first", listed)
+	}
+	if all, _ := sessions.List(""); len(all) != 3 {
+		t.Fatalf("List() = %d sessions, want 3", len(all))
+	}
+
+	if err := sessions.RevokeUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, _ := sessions.List(""); len(remaining) != 1 || remaining[0].ID != ids[1] {
+		t.Fatalf("List() after RevokeUser(alice) = %v, want only the session of bob", remaining)
+	}
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	clock := newFakeClock()
+	userStore := model.NewInMemoryUserStore()
+	for _, username := range []string{"alice", "bob"} {
+		user, err := model.NewUser(username, "secret", "user", bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := userStore.Save(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newTestAuthServer(t, clock, userStore, NewLoginLimiter(3, time.Minute, time.Hour, clock))
+
+	login := func(username string) context.Context {
+		res, err := server.Login(context.Background(), &pb.LoginRequest{Username: username, Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := server.jwtManager.Verify(res.GetAccessToken())
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+		return ContextWithPrincipal(context.Background(), principalFromClaims(claims))
+	}
+	alice := login("alice")
+	login("alice")
+	bob := login("bob")
+	admin := ContextWithPrincipal(context.Background(), &Principal{Username: "root", Scopes: []string{ScopeUserAdmin}})
+
+	res, err := server.ListSessions(alice, &pb.ListSessionsRequest{})
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(res.GetSessions()) != 2 || !res.GetSessions()[0].GetCurrent() || res.GetSessions()[1].GetCurrent() {
+		t.Fatalf("ListSessions() = %v, want both sessions of alice with the first one current", res.GetSessions())
+	}
+	if _, err := server.ListSessions(bob, &pb.ListSessionsRequest{Username: "alice"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("ListSessions() of another user error = %v, want %v", err, codes.PermissionDenied)
+	}
+	if res, err := server.ListSessions(admin, &pb.ListSessionsRequest{Username: "alice"}); err != nil || len(res.GetSessions()) != 2 {
+		t.Fatalf("ListSessions() by an admin = %v, %v, want both sessions of alice", res, err)
+	}
+
+	other := res.GetSessions()[1].GetId()
+	if _, err := server.RevokeSession(bob, &pb.RevokeSessionRequest{SessionId: other}); status.Code(err) != codes.NotFound {
+		t.Fatalf("RevokeSession() of another user error = %v, want %v", err, codes.NotFound)
+	}
+	if _, err := server.RevokeSession(alice, &pb.RevokeSessionRequest{SessionId: other}); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	if _, err := server.RevokeSession(admin, &pb.RevokeSessionRequest{SessionId: other}); status.Code(err) != codes.NotFound {
+		t.Fatalf("RevokeSession() of a revoked session error = %v, want %v", err, codes.NotFound)
+	}
+	if res, _ := server.ListSessions(alice, &pb.ListSessionsRequest{}); len(res.GetSessions()) != 1 {
+		t.Fatalf("ListSessions() after RevokeSession() = %v, want one session", res.GetSessions())
+	}
+}
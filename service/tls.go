@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+func clientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth mode: %s", mode)
+	}
+}
+
+func serverTLSConfig(reloader *CertReloader, clientCAFile string, clientAuth string) (*tls.Config, error) {
+	authType, err := clientAuthType(clientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     authType,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+	} else if authType != tls.NoClientCert {
+		return nil, fmt.Errorf("client certificates need a client CA")
+	}
+
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no CA certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// certificateIdentity maps a verified client certificate to a user. The
+// username comes from the subject common name, falling back to the first SAN,
+// and the role from the first organizational unit.
+func certificateIdentity(cert *x509.Certificate) (*Principal, error) {
+	username := cert.Subject.CommonName
+	switch {
+	case username != "":
+	case len(cert.DNSNames) > 0:
+		username = cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		username = cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		username = cert.URIs[0].String()
+	default:
+		return nil, fmt.Errorf("certificate has no subject name")
+	}
+
+	if len(cert.Subject.OrganizationalUnit) == 0 {
+		return nil, fmt.Errorf("certificate has no organizational unit for the role")
+	}
+
+	return &Principal{Username: username, Role: cert.Subject.OrganizationalUnit[0], AuthMethod: AuthMethodCertificate}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate and its key for
+// subject to dir and returns the parsed certificate with both paths.
+func writeTestCertificate(t *testing.T, dir string, subject pkix.Name) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               subject,
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, subject.CommonName+".crt")
+	keyFile := filepath.Join(dir, subject.CommonName+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, certFile, keyFile
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeTestCertificate(t, dir, pkix.Name{CommonName: "server"})
+	_, caFile, _ := writeTestCertificate(t, dir, pkix.Name{CommonName: "client-ca"})
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := serverTLSConfig(reloader, caFile, "require")
+	if err != nil {
+		t.Fatalf("serverTLSConfig() error = %v", err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Fatalf("serverTLSConfig() does not require verified client certificates")
+	}
+
+	if _, err := serverTLSConfig(reloader, "", "require"); err == nil {
+		t.Fatal("expected client certificates without a client CA to be rejected")
+	}
+	if _, err := serverTLSConfig(reloader, "", "sometimes"); err == nil {
+		t.Fatal("expected an unknown client auth mode to be rejected")
+	}
+	if _, err := serverTLSConfig(reloader, certFile+".missing", "none"); err == nil {
+		t.Fatal("expected a missing client CA to be rejected")
+	}
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.org/orders")
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		username string
+		wantErr  bool
+	}{
+		{"common name", &x509.Certificate{Subject: pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"admin"}}, DNSNames: []string{"host"}}, "alice", false},
+		{"DNS name", &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{"user"}}, DNSNames: []string{"host"}}, "host", false},
+		{"email", &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{"user"}}, EmailAddresses: []string{"bob@example.org"}}, "bob@example.org", false},
+		{"URI", &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{"user"}}, URIs: []*url.URL{uri}}, uri.String(), false},
+		{"no name", &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{"user"}}}, "", true},
+		{"no role", &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}, "", true},
+	}
+	for _, test := range tests {
+		claims, err := certificateIdentity(test.cert)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: certificateIdentity() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && claims.Username != test.username {
+			t.Errorf("%s: username = %q, want %q", test.name, claims.Username, test.username)
+		}
+	}
+}
+
+func TestAuthInterceptorCertificateIdentity(t *testing.T) {
+	cert, _, _ := writeTestCertificate(t, t.TempDir(), pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"admin"}})
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+
+	interceptor, _, _ := newTestInterceptor(t, false)
+	if err := callUnary(interceptor, ctx, testMethod); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("error without certificate identity = %v, want %v", err, codes.Unauthenticated)
+	}
+
+	WithCertificateIdentity()(interceptor)
+	if err := callUnary(interceptor, ctx, testMethod); err != nil {
+		t.Fatalf("error with certificate identity = %v", err)
+	}
+
+	unverified := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{}})
+	if err := callUnary(interceptor, unverified, testMethod); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("error of an unverified peer = %v, want %v", err, codes.Unauthenticated)
+	}
+}
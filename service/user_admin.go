@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/simp7/pracgrpc/model"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// reservedUsernamePrefixes start the names of principals that are not users,
+// so no user can be mistaken for an api key or a client.
+var reservedUsernamePrefixes = []string{apiKeyUsernamePrefix, clientIDPrefix}
+
+type UserAdminServer struct {
+	userStore      model.UserStore
+	clientStore    model.ClientStore
+	jwtManager     *JWTManager
+	sessions       *SessionManager
+	policy         AccessPolicy
+	passwordPolicy *model.PasswordPolicy
+	passwordCost   int
+	impersonation  time.Duration
+	pb.UnimplementedUserAdminServer
+}
+
+func NewUserAdminServer(userStore model.UserStore, clientStore model.ClientStore, jwtManager *JWTManager, sessions *SessionManager, policy AccessPolicy, passwordPolicy *model.PasswordPolicy, passwordCost int, impersonation time.Duration) *UserAdminServer {
+	return &UserAdminServer{userStore, clientStore, jwtManager, sessions, policy, passwordPolicy, passwordCost, impersonation, pb.UnimplementedUserAdminServer{}}
+}
+
+func (server *UserAdminServer) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.UserInfo, error) {
+	if req.GetUsername() == "" || req.GetPassword() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "username and password are required")
+	}
+	for _, prefix := range reservedUsernamePrefixes {
+		if strings.HasPrefix(req.GetUsername(), prefix) {
+			return nil, status.Errorf(codes.InvalidArgument, "username may not start with %q, which is reserved", prefix)
+		}
+	}
+	if _, ok := server.policy.RoleScopes(req.GetRole()); !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown role: %s", req.GetRole())
+	}
+	if err := server.passwordPolicy.Validate(req.GetUsername(), req.GetPassword()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	user, err := model.NewUser(req.GetUsername(), req.GetPassword(), req.GetRole(), server.passwordCost)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot create user: %v", err)
+	}
+
+	err = server.userStore.Save(user)
+	if errors.Is(err, model.ErrAlreadyExists) {
+		return nil, status.Errorf(codes.AlreadyExists, "user already exists: %s", user.Username)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save user: %v", err)
+	}
+
+	log.Printf("user created: %s (%s)", user.Username, user.Role)
+	return userInfo(user), nil
+}
+
+func (server *UserAdminServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.UserInfo, error) {
+	user, err := server.findUser(req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+	return userInfo(user), nil
+}
+
+func (server *UserAdminServer) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	pageSize := int(req.GetPageSize())
+	if pageSize < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "page size must not be negative")
+	}
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	users, err := server.userStore.List(req.GetPageToken(), pageSize+1)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list users: %v", err)
+	}
+
+	res := &pb.ListUsersResponse{}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		res.NextPageToken = users[pageSize-1].Username
+	}
+	for _, user := range users {
+		res.Users = append(res.Users, userInfo(user))
+	}
+	return res, nil
+}
+
+func (server *UserAdminServer) UpdateRole(ctx context.Context, req *pb.UpdateRoleRequest) (*pb.UserInfo, error) {
+	if _, ok := server.policy.RoleScopes(req.GetRole()); !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown role: %s", req.GetRole())
+	}
+
+	user, err := server.findUser(req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = req.GetRole()
+	if err := server.updateUser(user); err != nil {
+		return nil, err
+	}
+
+	log.Printf("role of user %s changed to %s", user.Username, user.Role)
+	return userInfo(user), nil
+}
+
+func (server *UserAdminServer) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*emptypb.Empty, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "caller is not authenticated")
+	}
+
+	self := principal.Username == req.GetUsername()
+	if !self && !principal.HasScope(ScopeUserAdmin) {
+		return nil, status.Errorf(codes.PermissionDenied, "cannot change password of another user")
+	}
+	if err := server.passwordPolicy.Validate(req.GetUsername(), req.GetNewPassword()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	user, err := server.findUser(req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+
+	if self && !user.IsCorrectPassword(req.GetOldPassword()) {
+		return nil, status.Errorf(codes.PermissionDenied, "incorrect password")
+	}
+
+	if err := user.SetPassword(req.GetNewPassword(), server.passwordCost); err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot change password: %v", err)
+	}
+	if err := server.updateUser(user); err != nil {
+		return nil, err
+	}
+
+	log.Printf("password of user %s changed by %s", user.Username, principal.Username)
+	return &emptypb.Empty{}, nil
+}
+
+func (server *UserAdminServer) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*emptypb.Empty, error) {
+	err := server.userStore.Delete(req.GetUsername())
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "user does not exist")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete user: %v", err)
+	}
+
+	if err := revokeUserTokens(server.jwtManager, server.sessions, req.GetUsername()); err != nil {
+		return nil, err
+	}
+
+	log.Printf("user deleted: %s", req.GetUsername())
+	return &emptypb.Empty{}, nil
+}
+
+// clientIDPrefix starts the ID of every client. Client tokens carry the ID as
+// their subject, so no user may take a name with this prefix.
+const clientIDPrefix = "client-"
+
+// RegisterClient creates a backend client for the client-credentials grant.
+// The secret is returned only once and the client may not hold scopes its
+// registrar lacks.
+func (server *UserAdminServer) RegisterClient(ctx context.Context, req *pb.RegisterClientRequest) (*pb.RegisterClientResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "caller is not authenticated")
+	}
+	if req.GetName() == "" || len(req.GetScopes()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "name and scopes are required")
+	}
+	if _, err := narrowScopes(principal.Scopes, req.GetScopes()); err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "cannot grant scopes: %v", err)
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate client id: %v", err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate client secret: %v", err)
+	}
+
+	client := model.NewClient(clientIDPrefix+id, secret, req.GetName(), req.GetScopes())
+	if err := server.clientStore.Save(client); err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save client: %v", err)
+	}
+
+	log.Printf("client %s (%s) registered by %s", client.ID, client.Name, principal.Username)
+	return &pb.RegisterClientResponse{ClientId: client.ID, ClientSecret: secret, Scopes: client.Scopes}, nil
+}
+
+// Impersonate lets support staff act as another user for a short while. The
+// token names the caller in its act claim, and the caller must hold every
+// scope of the impersonated user.
+func (server *UserAdminServer) Impersonate(ctx context.Context, req *pb.ImpersonateRequest) (*pb.ImpersonateResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "caller is not authenticated")
+	}
+	if principal.Machine || principal.Actor != "" {
+		return nil, status.Errorf(codes.PermissionDenied, "only users acting as themselves can impersonate")
+	}
+	if req.GetUsername() == "" || req.GetReason() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "username and reason are required")
+	}
+	if req.GetUsername() == principal.Username {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot impersonate yourself")
+	}
+
+	user, err := server.findUser(req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, ok := server.policy.RoleScopes(user.Role)
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "role %s is not defined by the policy", user.Role)
+	}
+	if _, err := narrowScopes(principal.Scopes, scopes); err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "cannot impersonate a user holding more scopes: %v", err)
+	}
+
+	session, err := server.sessions.StartImpersonation(ctx, user.Username, principal.Username, server.impersonation)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot start session: %v", err)
+	}
+
+	token, err := server.jwtManager.GenerateImpersonation(user, scopes, principal.Username, session.ID, server.impersonation)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate access token")
+	}
+
+	log.Printf("impersonation: %s started acting as %s: %s", principal.Username, user.Username, req.GetReason())
+	res := &pb.ImpersonateResponse{
+		AccessToken: token,
+		ExpiresIn:   int64(server.impersonation.Seconds()),
+		Scopes:      scopes,
+	}
+	return res, nil
+}
+
+func (server *UserAdminServer) findUser(username string) (*model.User, error) {
+	user, err := server.userStore.Find(username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+	if user == nil {
+		return nil, status.Errorf(codes.NotFound, "user does not exist")
+	}
+	return user, nil
+}
+
+func (server *UserAdminServer) updateUser(user *model.User) error {
+	err := server.userStore.Update(user)
+	if errors.Is(err, model.ErrNotFound) {
+		return status.Errorf(codes.NotFound, "user does not exist")
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot update user: %v", err)
+	}
+
+	return revokeUserTokens(server.jwtManager, server.sessions, user.Username)
+}
+
+func userInfo(user *model.User) *pb.UserInfo {
+	return &pb.UserInfo{Username: user.Username, Role: user.Role}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"github.com/simp7/pracgrpc/model"
+	pb "github.com/simp7/pracgrpc/model/ecommerce"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+type userAdminFixture struct {
+	server     *UserAdminServer
+	userStore  model.UserStore
+	jwtManager *JWTManager
+	policy     *PolicyStore
+	clock      *fakeClock
+}
+
+func newUserAdminFixture(t *testing.T) *userAdminFixture {
+	t.Helper()
+
+	clock := newFakeClock()
+	userStore := model.NewInMemoryUserStore()
+	jwtManager := newTestJWTManager(t, clock)
+	refreshTokens := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(clock), time.Hour, clock)
+	policy := newTestPolicyStore(t, testRoles)
+	sessions := NewSessionManager(NewInMemorySessionStore(clock), refreshTokens, clock)
+	server := NewUserAdminServer(userStore, model.NewInMemoryClientStore(), jwtManager, sessions, policy, model.NewPasswordPolicy(1, 1), bcrypt.MinCost, 15*time.Minute)
+
+	fixture := &userAdminFixture{server, userStore, jwtManager, policy, clock}
+	fixture.createUser(t, "admin", "root-pass", "admin")
+	fixture.createUser(t, "alice", "wonderland", "user")
+	fixture.createUser(t, "bob", "builder", "user")
+	return fixture
+}
+
+func (fixture *userAdminFixture) createUser(t *testing.T, username string, password string, role string) {
+	t.Helper()
+
+	req := &pb.CreateUserRequest{Username: username, Password: password, Role: role}
+	if _, err := fixture.server.CreateUser(context.Background(), req); err != nil {
+		t.Fatalf("CreateUser(%s) error = %v", username, err)
+	}
+}
+
+func (fixture *userAdminFixture) contextOf(t *testing.T, username string) (context.Context, string) {
+	t.Helper()
+
+	user, err := fixture.userStore.Find(username)
+	if err != nil || user == nil {
+		t.Fatalf("cannot find user %s: %v", username, err)
+	}
+	scopes, _ := fixture.policy.RoleScopes(user.Role)
+	token, err := fixture.jwtManager.Generate(user, scopes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := fixture.jwtManager.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// User revocations have second precision, so only tokens issued in an
+	// earlier second are revoked by a change of the user.
+	fixture.clock.Advance(time.Second)
+	return ContextWithPrincipal(context.Background(), principalFromClaims(claims)), token
+}
+
+func TestCreateUserValidation(t *testing.T) {
+	fixture := newUserAdminFixture(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		req  *pb.CreateUserRequest
+		code codes.Code
+	}{
+		{"missing password", &pb.CreateUserRequest{Username: "carol", Role: "user"}, codes.InvalidArgument},
+		{"unknown role", &pb.CreateUserRequest{Username: "carol", Password: "secret", Role: "root"}, codes.InvalidArgument},
+		{"duplicate", &pb.CreateUserRequest{Username: "alice", Password: "secret", Role: "user"}, codes.AlreadyExists},
+	}
+	for _, test := range tests {
+		if _, err := fixture.server.CreateUser(ctx, test.req); status.Code(err) != test.code {
+			t.Errorf("%s: CreateUser() error = %v, want %v", test.name, err, test.code)
+		}
+	}
+
+	info, err := fixture.server.GetUser(ctx, &pb.GetUserRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.GetRole() != "user" {
+		t.Fatalf("GetUser() role = %q, want user", info.GetRole())
+	}
+}
+
+func TestListUsersPaginates(t *testing.T) {
+	fixture := newUserAdminFixture(t)
+	ctx := context.Background()
+
+	var usernames []string
+	req := &pb.ListUsersRequest{PageSize: 2}
+	for {
+		res, err := fixture.server.ListUsers(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, user := range res.GetUsers() {
+			usernames = append(usernames, user.GetUsername())
+		}
+		if res.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = res.GetNextPageToken()
+	}
+
+	want := []string{"admin", "alice", "bob"}
+	if len(usernames) != len(want) {
+		t.Fatalf("ListUsers() = %v, want %v", usernames, want)
+	}
+	for i := range want {
+		if usernames[i] != want[i] {
+			t.Fatalf("ListUsers() = %v, want %v", usernames, want)
+		}
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	fixture := newUserAdminFixture(t)
+	aliceContext, _ := fixture.contextOf(t, "alice")
+	adminContext, _ := fixture.contextOf(t, "admin")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *pb.ChangePasswordRequest
+		code codes.Code
+	}{
+		{"wrong old password", aliceContext, &pb.ChangePasswordRequest{Username: "alice", OldPassword: "wrong", NewPassword: "new"}, codes.PermissionDenied},
+		{"another user", aliceContext, &pb.ChangePasswordRequest{Username: "bob", NewPassword: "new"}, codes.PermissionDenied},
+		{"admin for another user", adminContext, &pb.ChangePasswordRequest{Username: "bob", NewPassword: "can-we-fix-it"}, codes.OK},
+		{"self", aliceContext, &pb.ChangePasswordRequest{Username: "alice", OldPassword: "wonderland", NewPassword: "looking-glass"}, codes.OK},
+	}
+	for _, test := range tests {
+		if _, err := fixture.server.ChangePassword(test.ctx, test.req); status.Code(err) != test.code {
+			t.Errorf("%s: ChangePassword() error = %v, want %v", test.name, err, test.code)
+		}
+	}
+
+	for username, password := range map[string]string{"alice": "looking-glass", "bob": "can-we-fix-it"} {
+		user, err := fixture.userStore.Find(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !user.IsCorrectPassword(password) {
+			t.Errorf("password of %s was not changed", username)
+		}
+	}
+}
+
+func TestUserChangesRevokeTokens(t *testing.T) {
+	fixture := newUserAdminFixture(t)
+	ctx := context.Background()
+
+	_, aliceToken := fixture.contextOf(t, "alice")
+	_, bobToken := fixture.contextOf(t, "bob")
+
+	// Tokens carry the role, so a role change must invalidate them.
+	if _, err := fixture.server.UpdateRole(ctx, &pb.UpdateRoleRequest{Username: "alice", Role: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fixture.server.DeleteUser(ctx, &pb.DeleteUserRequest{Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for username, token := range map[string]string{"alice": aliceToken, "bob": bobToken} {
+		if _, err := fixture.jwtManager.Verify(token); err == nil {
+			t.Errorf("expected the token of %s to be revoked", username)
+		}
+	}
+	if _, err := fixture.server.GetUser(ctx, &pb.GetUserRequest{Username: "bob"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetUser() of a deleted user error = %v, want %v", err, codes.NotFound)
+	}
+	if _, err := fixture.server.DeleteUser(ctx, &pb.DeleteUserRequest{Username: "bob"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("DeleteUser() of a deleted user error = %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestPasswordPolicyIsEnforced(t *testing.T) {
+	fixture := newUserAdminFixture(t)
+	fixture.server.passwordPolicy = model.NewPasswordPolicy(12, 3)
+	aliceContext, _ := fixture.contextOf(t, "alice")
+
+	_, err := fixture.server.CreateUser(context.Background(), &pb.CreateUserRequest{Username: "carol", Password: "short", Role: "user"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CreateUser() with a weak password error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	req := &pb.ChangePasswordRequest{Username: "alice", OldPassword: "wonderland", NewPassword: "alice-Password-1"}
+	if _, err := fixture.server.ChangePassword(aliceContext, req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("ChangePassword() to a password containing the username error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	req.NewPassword = "Correct-Horse-7"
+	if _, err := fixture.server.ChangePassword(aliceContext, req); err != nil {
+		t.Fatalf("ChangePassword() to a strong password error = %v", err)
+	}
+}
+
+func TestCreateUserRejectsReservedPrefixes(t *testing.T) {
+	fixture := newUserAdminFixture(t)
+
+	for _, prefix := range []string{apiKeyUsernamePrefix, clientIDPrefix} {
+		req := &pb.CreateUserRequest{Username: prefix + "0123456789abcdef", Password: "secret", Role: "admin"}
+		if _, err := fixture.server.CreateUser(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("CreateUser(%s) error = %v, want %v", req.GetUsername(), err, codes.InvalidArgument)
+		}
+		if found, err := fixture.userStore.Find(req.GetUsername()); err != nil || found != nil {
+			t.Errorf("Find(%s) = %v, %v, want no user", req.GetUsername(), found, err)
+		}
+	}
+}
+
+func TestRegisterClient(t *testing.T) {
+	fixture := newUserAdminFixture(t)
+	admin, _ := fixture.contextOf(t, "admin")
+	alice, _ := fixture.contextOf(t, "alice")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *pb.RegisterClientRequest
+		code codes.Code
+	}{
+		{"missing name", admin, &pb.RegisterClientRequest{Scopes: []string{"product:read"}}, codes.InvalidArgument},
+		{"missing scopes", admin, &pb.RegisterClientRequest{Name: "billing"}, codes.InvalidArgument},
+		{"scope the registrar lacks", alice, &pb.RegisterClientRequest{Name: "billing", Scopes: []string{"product:write"}}, codes.PermissionDenied},
+		{"anonymous", context.Background(), &pb.RegisterClientRequest{Name: "billing", Scopes: []string{"product:read"}}, codes.Unauthenticated},
+	}
+	for _, test := range tests {
+		if _, err := fixture.server.RegisterClient(test.ctx, test.req); status.Code(err) != test.code {
+			t.Errorf("%s: RegisterClient() error = %v, want %v", test.name, err, test.code)
+		}
+	}
+
+	res, err := fixture.server.RegisterClient(admin, &pb.RegisterClientRequest{Name: "billing", Scopes: []string{"product:write"}})
+	if err != nil {
+		t.Fatalf("RegisterClient() error = %v", err)
+	}
+	if !strings.HasPrefix(res.GetClientId(), clientIDPrefix) || res.GetClientSecret() == "" {
+		t.Fatalf("RegisterClient() = %v, want a prefixed id and a secret", res)
+	}
+
+	client, err := fixture.server.clientStore.Find(res.GetClientId())
+	if err != nil || client == nil {
+		t.Fatalf("Find() = %v, %v, want the registered client", client, err)
+	}
+	if !client.IsCorrectSecret(res.GetClientSecret()) || client.HashedSecret == res.GetClientSecret() {
+		t.Fatal("expected the client secret to be stored hashed")
+	}
+}
+
+func TestImpersonationEndsWithTheAdminSessions(t *testing.T) {
+	clock := newFakeClock()
+	userStore := model.NewInMemoryUserStore()
+	for _, user := range []*model.User{{Username: "admin1", Role: "admin"}, {Username: "alice", Role: "user"}} {
+		if err := userStore.Save(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy, err := NewPolicyStore("policy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminScopes, _ := policy.RoleScopes("admin")
+
+	jwtManager := newTestJWTManager(t, clock)
+	refreshTokens := NewRefreshTokenManager(NewInMemoryRefreshTokenStore(clock), time.Hour, clock)
+	sessions := NewSessionManager(NewInMemorySessionStore(clock), refreshTokens, clock)
+	server := NewUserAdminServer(userStore, model.NewInMemoryClientStore(), jwtManager, sessions, policy, nil, 0, 5*time.Minute)
+
+	ctx := ContextWithPrincipal(context.Background(), &Principal{Username: "admin1", Role: "admin", Scopes: adminScopes, AuthMethod: AuthMethodJWT})
+	res, err := server.Impersonate(ctx, &pb.ImpersonateRequest{Username: "alice", Reason: "support ticket"})
+	if err != nil {
+		t.Fatalf("Impersonate() error = %v", err)
+	}
+
+	claims, err := jwtManager.Verify(res.GetAccessToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.SessionID == "" || claims.Actor == nil || claims.Actor.Subject != "admin1" {
+		t.Fatalf("claims = %+v, want a session and admin1 as actor", claims)
+	}
+	if err := sessions.Verify(claims.SessionID); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if err := revokeUserTokens(jwtManager, sessions, "admin1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.Verify(claims.SessionID); err == nil {
+		t.Fatal("expected the impersonation session to end with the admin sessions")
+	}
+	if _, err := jwtManager.Verify(res.GetAccessToken()); err == nil {
+		t.Fatal("expected the impersonation token to be revoked with the admin tokens")
+	}
+}
+
+func TestImpersonate(t *testing.T) {
+	fixture := newUserAdminFixture(t)
+	admin, _ := fixture.contextOf(t, "admin")
+	alice, _ := fixture.contextOf(t, "alice")
+	machine := ContextWithPrincipal(context.Background(), &Principal{Username: "client-1", Scopes: []string{ScopeUserAdmin}, Machine: true})
+	acting := ContextWithPrincipal(context.Background(), &Principal{Username: "bob", Scopes: []string{ScopeUserAdmin}, Actor: "admin"})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *pb.ImpersonateRequest
+		code codes.Code
+	}{
+		{"machine", machine, &pb.ImpersonateRequest{Username: "alice", Reason: "support"}, codes.PermissionDenied},
+		{"already impersonating", acting, &pb.ImpersonateRequest{Username: "alice", Reason: "support"}, codes.PermissionDenied},
+		{"no reason", admin, &pb.ImpersonateRequest{Username: "alice"}, codes.InvalidArgument},
+		{"self", admin, &pb.ImpersonateRequest{Username: "admin", Reason: "support"}, codes.InvalidArgument},
+		{"unknown user", admin, &pb.ImpersonateRequest{Username: "nobody", Reason: "support"}, codes.NotFound},
+		{"more scopes", alice, &pb.ImpersonateRequest{Username: "admin", Reason: "support"}, codes.PermissionDenied},
+	}
+	for _, test := range tests {
+		if _, err := fixture.server.Impersonate(test.ctx, test.req); status.Code(err) != test.code {
+			t.Errorf("%s: Impersonate() error = %v, want %v", test.name, err, test.code)
+		}
+	}
+
+	res, err := fixture.server.Impersonate(admin, &pb.ImpersonateRequest{Username: "alice", Reason: "support ticket"})
+	if err != nil {
+		t.Fatalf("Impersonate() error = %v", err)
+	}
+	if res.GetExpiresIn() != int64((15 * time.Minute).Seconds()) {
+		t.Fatalf("Impersonate() expires in %ds, want the impersonation duration", res.GetExpiresIn())
+	}
+	claims, err := fixture.jwtManager.Verify(res.GetAccessToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal := principalFromClaims(claims)
+	if principal.Username != "alice" || principal.Actor != "admin" || principal.HasScope(ScopeUserAdmin) {
+		t.Fatalf("principal = %+v, want alice acted by admin with the scopes of alice", principal)
+	}
+}
+
+func TestImpersonationSessions(t *testing.T) {
+	clock := newFakeClock()
+	userStore := model.NewInMemoryUserStore()
+	for _, user := range []*model.User{{Username: "admin1", Role: "admin"}, {Username: "alice", Role: "user"}, {Username: "bob", Role: "user"}} {
+		if err := userStore.Save(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	auth := newTestAuthServer(t, clock, userStore, NewLoginLimiter(3, time.Minute, time.Hour, clock))
+	policy := auth.policy.(*PolicyStore)
+	adminScopes, _ := policy.RoleScopes("admin")
+	server := NewUserAdminServer(userStore, model.NewInMemoryClientStore(), auth.jwtManager, auth.sessions, policy, nil, 0, 5*time.Minute)
+
+	actorCtx := ContextWithPrincipal(context.Background(), &Principal{Username: "admin1", Role: "admin", Scopes: adminScopes, AuthMethod: AuthMethodJWT})
+	impersonate := func() (context.Context, string) {
+		res, err := server.Impersonate(actorCtx, &pb.ImpersonateRequest{Username: "alice", Reason: "support ticket"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := auth.jwtManager.Verify(res.GetAccessToken())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ContextWithPrincipal(context.Background(), principalFromClaims(claims)), claims.SessionID
+	}
+	impersonation, first := impersonate()
+	_, second := impersonate()
+
+	alice := ContextWithPrincipal(context.Background(), &Principal{Username: "alice", Role: "user", AuthMethod: AuthMethodJWT})
+	listed, err := auth.ListSessions(alice, &pb.ListSessionsRequest{})
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(listed.GetSessions()) != 2 || listed.GetSessions()[0].GetActor() != "admin1" {
+		t.Fatalf("ListSessions() = %v, want the impersonation sessions of alice naming admin1", listed.GetSessions())
+	}
+
+	if _, err := auth.RevokeSession(impersonation, &pb.RevokeSessionRequest{SessionId: first}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("RevokeSession() while impersonating error = %v, want %v", err, codes.PermissionDenied)
+	}
+	bob := ContextWithPrincipal(context.Background(), &Principal{Username: "bob", Role: "user", AuthMethod: AuthMethodJWT})
+	if _, err := auth.RevokeSession(bob, &pb.RevokeSessionRequest{SessionId: first}); status.Code(err) != codes.NotFound {
+		t.Fatalf("RevokeSession() by another user error = %v, want %v", err, codes.NotFound)
+	}
+
+	// The actor may end the sessions it started even without user:admin.
+	actor := ContextWithPrincipal(context.Background(), &Principal{Username: "admin1", Role: "admin", AuthMethod: AuthMethodJWT})
+	if _, err := auth.RevokeSession(actor, &pb.RevokeSessionRequest{SessionId: first}); err != nil {
+		t.Fatalf("RevokeSession() by the actor error = %v", err)
+	}
+	if _, err := auth.RevokeSession(alice, &pb.RevokeSessionRequest{SessionId: second}); err != nil {
+		t.Fatalf("RevokeSession() by the impersonated user error = %v", err)
+	}
+	for _, id := range []string{first, second} {
+		if err := auth.sessions.Verify(id); err == nil {
+			t.Fatalf("expected impersonation session %s to be revoked", id)
+		}
+	}
+}